@@ -2,10 +2,16 @@ package adventofcode2016_test
 
 import (
 	"crypto/md5"
+	"encoding/json"
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
 )
 
 var _ = fmt.Sprintf
@@ -13,27 +19,45 @@ var _ = fmt.Sprintf
 type KeyGenerator struct {
 	salt         string
 	stretch      int
+	hashing      HashAlgorithm  // from day 5
 	foundKeys    map[int]int    // ordinal → index
-	cachedHashes map[int][]byte // index → md5
+	cachedHashes map[int][]byte // index → hash
+	scanner      *HashScanner   // if not nil, keys are found from its events instead
 }
 
 func NewKeyGenerator(salt string) *KeyGenerator {
-	return &KeyGenerator{salt, 0, make(map[int]int), make(map[int][]byte)}
+	return NewKeyGeneratorWithHash(salt, 0, md5Algorithm)
 }
 
 func NewStretchedKeyGenerator(salt string) *KeyGenerator {
-	return &KeyGenerator{salt, 2016, make(map[int]int), make(map[int][]byte)}
+	return NewKeyGeneratorWithHash(salt, 2016, md5Algorithm)
 }
 
-func (kg *KeyGenerator) calculateHash(index int) []byte {
-	value := kg.salt + strconv.Itoa(index)
-	hash := []byte(fmt.Sprintf("%x", md5.Sum([]byte(value))))
-	for j := 0; j < kg.stretch; j++ {
-		hash = []byte(fmt.Sprintf("%x", md5.Sum(hash)))
+func NewKeyGeneratorWithHash(salt string, stretch int, hashing HashAlgorithm) *KeyGenerator {
+	return &KeyGenerator{salt, stretch, hashing, make(map[int]int), make(map[int][]byte), nil}
+}
+
+// NewScannedKeyGenerator finds keys from the scanner's triple and quintuple
+// events, so that a cached scan makes it instant.
+func NewScannedKeyGenerator(scanner *HashScanner) *KeyGenerator {
+	kg := NewKeyGeneratorWithHash(scanner.salt, scanner.stretch, scanner.hashing)
+	kg.scanner = scanner
+	return kg
+}
+
+func saltedHash(hashing HashAlgorithm, salt string, index int, stretch int) []byte {
+	value := salt + strconv.Itoa(index)
+	hash := hashing.hasher.HexSum([]byte(value))
+	for j := 0; j < stretch; j++ {
+		hash = hashing.hasher.HexSum(hash)
 	}
 	return hash
 }
 
+func (kg *KeyGenerator) calculateHash(index int) []byte {
+	return saltedHash(kg.hashing, kg.salt, index, kg.stretch)
+}
+
 func (kg *KeyGenerator) Hash(index int) []byte {
 	// read-through cache
 	hash, ok := kg.cachedHashes[index]
@@ -52,11 +76,15 @@ func (kg *KeyGenerator) calculateKey(position int) int {
 		start = kg.Key(position-1) + 1
 	}
 
+	if kg.scanner != nil {
+		return kg.calculateScannedKey(start)
+	}
+
 	for j := start; ; j++ {
-		repeatEh, repeatChar := any3Repeat(kg.Hash(j))
+		repeatEh, repeatChar := anyRepeat(kg.Hash(j), kg.hashing.rules.keyRepeat)
 		if repeatEh {
 			for k := j + 1; k < j+1000; k++ {
-				if specific5Repeat(kg.Hash(k), repeatChar) {
+				if specificRepeat(kg.Hash(k), repeatChar, kg.hashing.rules.keyConfirm) {
 					return j
 				}
 			}
@@ -65,6 +93,18 @@ func (kg *KeyGenerator) calculateKey(position int) int {
 	return -1
 }
 
+func (kg *KeyGenerator) calculateScannedKey(start int) int {
+	for j := start; ; j++ {
+		for _, triple := range kg.scanner.Events(j, j+1, FirstTriple) {
+			for _, quintuple := range kg.scanner.Events(j+1, j+1000, Quintuple) {
+				if quintuple.Char == triple.Char {
+					return j
+				}
+			}
+		}
+	}
+}
+
 func (kg *KeyGenerator) Key(position int) int {
 	// read-through cache
 	value, ok := kg.foundKeys[position]
@@ -79,30 +119,196 @@ func (kg *KeyGenerator) Key(position int) int {
 // ----------------------------------------
 // utility methods
 
-func any3Repeat(hash []byte) (bool, byte) {
-	//  fmt.Printf("MIKE: looking for 3 repeats in %s\n", hash)
-	for j := 0; j < len(hash)-2; j++ {
-		if hash[j] == hash[j+1] && hash[j] == hash[j+2] {
+func anyRepeat(hash []byte, length int) (bool, byte) {
+	//  fmt.Printf("MIKE: looking for %d repeats in %s\n", length, hash)
+	for j := 0; j <= len(hash)-length; j++ {
+		if specificRepeat(hash[j:j+length], hash[j], length) {
 			return true, hash[j]
 		}
 	}
 	return false, '-'
 }
 
-func specific5Repeat(hash []byte, char byte) bool {
-	//	fmt.Printf("MIKE: looking for 5 repeats of %c in %s\n", char, hash)
-	for j := 0; j < len(hash)-4; j++ {
-		if hash[j] == char &&
-			hash[j] == hash[j+1] &&
-			hash[j] == hash[j+2] &&
-			hash[j] == hash[j+3] &&
-			hash[j] == hash[j+4] {
-			return true
+func specificRepeat(hash []byte, char byte, length int) bool {
+	//	fmt.Printf("MIKE: looking for %d repeats of %c in %s\n", length, char, hash)
+	run := 0
+	for _, b := range hash {
+		if b == char {
+			run++
+			if run == length {
+				return true
+			}
+		} else {
+			run = 0
 		}
 	}
 	return false
 }
 
+// ----------------------------------------
+// salted hash scanning, shared with day 5
+//
+//  HashScanner hashes salt+index once per index, in order, and records an
+//  event for each pattern it finds. Events are kept sorted by index, and may
+//  be cached on disk keyed by algorithm, salt, stretch and patterns.
+
+type HashEventKind int
+
+const (
+	LeadingZeros HashEventKind = iota // the hash starts with HashPatterns.leadingZeros zeros
+	FirstTriple                       // the first run of HashPatterns.triple identical characters
+	Quintuple                         // every run of HashPatterns.quintuple identical characters
+)
+
+type HashEvent struct {
+	Index int           `json:"index"`
+	Kind  HashEventKind `json:"kind"`
+	Char  byte          `json:"char"` // the repeated character, for triples and quintuples
+	Hash  string        `json:"hash"`
+}
+
+// HashPatterns configures which events are recorded. Zero disables a pattern.
+type HashPatterns struct {
+	leadingZeros int
+	triple       int
+	quintuple    int
+}
+
+// patternsFromRules only takes the door prefix's leading zeros, since that's
+// all the scanner records.
+func patternsFromRules(rules HashRules) HashPatterns {
+	leadingZeros := len(rules.doorPrefix) - len(strings.TrimLeft(rules.doorPrefix, "0"))
+	return HashPatterns{leadingZeros, rules.keyRepeat, rules.keyConfirm}
+}
+
+var hashScanChunk = 1000
+
+type HashScanner struct {
+	salt      string
+	stretch   int
+	hashing   HashAlgorithm
+	patterns  HashPatterns
+	cachePath string // "" disables the cache
+	scanned   int    // every index below this has been hashed
+	events    []HashEvent
+}
+
+type hashScanCache struct {
+	Scanned int         `json:"scanned"`
+	Events  []HashEvent `json:"events"`
+}
+
+// NewHashScanner loads a previous scan from cacheDir if there is one.
+// Pass "" to disable the cache.
+func NewHashScanner(salt string, stretch int, hashing HashAlgorithm, patterns HashPatterns, cacheDir string) (*HashScanner, error) {
+	scanner := &HashScanner{salt, stretch, hashing, patterns, "", 0, make([]HashEvent, 0)}
+	if cacheDir == "" {
+		return scanner, nil
+	}
+
+	key := fmt.Sprintf("%s %s %d %d %d %d", hashing.name, salt, stretch,
+		patterns.leadingZeros, patterns.triple, patterns.quintuple)
+	scanner.cachePath = filepath.Join(cacheDir, md5sum(key)+".json")
+
+	data, err := ioutil.ReadFile(scanner.cachePath)
+	if os.IsNotExist(err) {
+		return scanner, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cache := hashScanCache{}
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("hash scan cache `%s`: %v", scanner.cachePath, err)
+	}
+	scanner.scanned = cache.Scanned
+	scanner.events = cache.Events
+	return scanner, nil
+}
+
+// Save writes everything scanned so far to the cache, if there is one.
+func (hs *HashScanner) Save() error {
+	if hs.cachePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(hashScanCache{hs.scanned, hs.events})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(hs.cachePath, data)
+}
+
+func (hs *HashScanner) scanIndex(index int) {
+	hash := saltedHash(hs.hashing, hs.salt, index, hs.stretch)
+
+	if hs.patterns.leadingZeros > 0 {
+		zeros := 0
+		for zeros < len(hash) && hash[zeros] == '0' {
+			zeros++
+		}
+		if zeros >= hs.patterns.leadingZeros {
+			hs.events = append(hs.events, HashEvent{index, LeadingZeros, '0', string(hash)})
+		}
+	}
+
+	foundTriple := false
+	for j := 0; j < len(hash); {
+		run := 1
+		for j+run < len(hash) && hash[j+run] == hash[j] {
+			run++
+		}
+		if hs.patterns.triple > 0 && !foundTriple && run >= hs.patterns.triple {
+			hs.events = append(hs.events, HashEvent{index, FirstTriple, hash[j], string(hash)})
+			foundTriple = true
+		}
+		if hs.patterns.quintuple > 0 && run >= hs.patterns.quintuple {
+			hs.events = append(hs.events, HashEvent{index, Quintuple, hash[j], string(hash)})
+		}
+		j += run
+	}
+}
+
+// ScanTo makes sure every index below `index` has been scanned, working in
+// chunks of hashScanChunk.
+func (hs *HashScanner) ScanTo(index int) {
+	for hs.scanned < index {
+		for j := hs.scanned; j < hs.scanned+hashScanChunk; j++ {
+			hs.scanIndex(j)
+		}
+		hs.scanned += hashScanChunk
+	}
+}
+
+// Events returns the events of the given kind with from <= index < to.
+func (hs *HashScanner) Events(from, to int, kind HashEventKind) []HashEvent {
+	hs.ScanTo(to)
+	first := sort.Search(len(hs.events), func(j int) bool { return hs.events[j].Index >= from })
+	events := make([]HashEvent, 0)
+	for j := first; j < len(hs.events) && hs.events[j].Index < to; j++ {
+		if hs.events[j].Kind == kind {
+			events = append(events, hs.events[j])
+		}
+	}
+	return events
+}
+
+// NextEvent returns the first event of the given kind at or after `from`,
+// scanning as far as it needs to.
+func (hs *HashScanner) NextEvent(from int, kind HashEventKind) HashEvent {
+	for {
+		to := from
+		if hs.scanned > to {
+			to = hs.scanned
+		}
+		to += hashScanChunk
+		events := hs.Events(from, to, kind)
+		if len(events) > 0 {
+			return events[0]
+		}
+		// nothing in [from, to), so carry on from to, never going back
+		from = to
+	}
+}
+
 // ----------------------------------------
 // tests
 
@@ -122,6 +328,28 @@ var _ = Describe("Day14", func() {
 			})
 		})
 
+		Context("other hash algorithms", func() {
+			It("generates keys from SHA-1 hashes", func() {
+				kg := NewKeyGeneratorWithHash("abc", 0, sha1Algorithm)
+				Expect(kg.Key(1)).To(Equal(1135))
+				Expect(kg.Key(2)).To(Equal(1282))
+			})
+
+			It("generates keys from SHA-256 hashes", func() {
+				Expect(NewKeyGeneratorWithHash("abc", 0, sha256Algorithm).Key(2)).To(Equal(134))
+			})
+		})
+
+		Context("scanned", func() {
+			It("finds the same keys as the hash-at-a-time generator", func() {
+				scanner, err := NewHashScanner("abc", 0, md5Algorithm, patternsFromRules(defaultHashRules), "")
+				Expect(err).NotTo(HaveOccurred())
+				kg := NewScannedKeyGenerator(scanner)
+				Expect(kg.Key(1)).To(Equal(39))
+				Expect(kg.Key(64)).To(Equal(22728))
+			})
+		})
+
 		Context("stretched", func() {
 			It("generates the first based on a salt", func() {
 				Expect(NewStretchedKeyGenerator("abc").Key(1)).To(Equal(10))
@@ -133,6 +361,69 @@ var _ = Describe("Day14", func() {
 		})
 	})
 
+	Describe("HashScanner", func() {
+		var dir string
+
+		BeforeEach(func() {
+			dir, _ = ioutil.TempDir("", "day14")
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("records leading zeros, the first triple and every quintuple in one pass", func() {
+			scanner, _ := NewHashScanner("abc", 0, md5Algorithm, HashPatterns{3, 3, 5}, "")
+			Expect(scanner.Events(18, 19, FirstTriple)).To(Equal([]HashEvent{
+				{18, FirstTriple, '8', md5sum("abc18")},
+			}))
+			Expect(scanner.Events(800, 1000, Quintuple)).To(Equal([]HashEvent{
+				{816, Quintuple, 'e', md5sum("abc816")},
+			}))
+			zeros := scanner.NextEvent(0, LeadingZeros)
+			Expect(zeros.Hash).To(HavePrefix("000"))
+			Expect(scanner.Events(0, zeros.Index, LeadingZeros)).To(BeEmpty())
+		})
+
+		It("takes only the door prefix's leading zeros from the rules", func() {
+			Expect(patternsFromRules(defaultHashRules)).To(Equal(HashPatterns{5, 3, 5}))
+			Expect(patternsFromRules(HashRules{"00a0", 3, 5}).leadingZeros).To(Equal(2))
+		})
+
+		It("never looks before from, even past the scanned range", func() {
+			scanner, _ := NewHashScanner("abc", 0, md5Algorithm, HashPatterns{3, 0, 0}, "")
+			event := scanner.NextEvent(5000, LeadingZeros)
+			Expect(event.Index).To(BeNumerically(">=", 5000))
+
+			door, _ := NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 3, 3, 3, 4})
+			Expect(event.Index).To(Equal(NewSequentialDoorHits(door, 5000).Next().index))
+		})
+
+		It("caches scans on disk so reruns don't hash at all", func() {
+			calls := 0
+			counting := HashAlgorithm{"md5", HasherFunc(func(input []byte) []byte {
+				calls++
+				digest := md5.Sum(input)
+				return digest[:]
+			}), defaultHashRules}
+
+			scanner, err := NewHashScanner("abc", 0, counting, patternsFromRules(defaultHashRules), dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(NewScannedKeyGenerator(scanner).Key(2)).To(Equal(92))
+			Expect(scanner.Save()).To(Succeed())
+			Expect(calls).To(BeNumerically(">", 0))
+
+			calls = 0
+			rerun, err := NewHashScanner("abc", 0, counting, patternsFromRules(defaultHashRules), dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(NewScannedKeyGenerator(rerun).Key(2)).To(Equal(92))
+			Expect(calls).To(Equal(0))
+
+			other, _ := NewHashScanner("xyz", 0, counting, patternsFromRules(defaultHashRules), dir)
+			Expect(other.scanned).To(Equal(0))
+		})
+	})
+
 	Describe("the puzzle", func() {
 		Describe("star 1", func() {
 			It("finds the 64th key", func() {
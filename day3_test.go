@@ -16,6 +16,143 @@ func (self Triangle) valid() bool {
 	return self.a+self.b > self.c && self.a+self.c > self.b && self.b+self.c > self.a
 }
 
+func (self Triangle) polygon() Polygon {
+	return Polygon{[]uint{self.a, self.b, self.c}}
+}
+
+// ----------------------------------------
+// polygons with any number of sides
+
+type PolygonShape int
+
+const (
+	PolygonValid       PolygonShape = iota
+	PolygonDegenerate               // longest side equals the sum of the others; collapses to a line
+	PolygonImpossible               // longest side exceeds the sum of the others
+	PolygonTooFewSides              // fewer than three sides can never enclose an area
+)
+
+func (s PolygonShape) String() string {
+	switch s {
+	case PolygonValid:
+		return "valid"
+	case PolygonDegenerate:
+		return "degenerate"
+	case PolygonImpossible:
+		return "impossible"
+	case PolygonTooFewSides:
+		return "too few sides"
+	}
+	return fmt.Sprintf("PolygonShape(%d)", int(s))
+}
+
+type Polygon struct {
+	sides []uint
+}
+
+// longestSide returns the longest side and how far the others fall short
+// of it, if they do. The others are taken away from the longest one at a
+// time rather than summed, so huge sides can't overflow.
+func (p Polygon) longestSide() (longest uint, shortfall uint, exceeds bool) {
+	jLongest := 0
+	for j, side := range p.sides {
+		if side > p.sides[jLongest] {
+			jLongest = j
+		}
+	}
+	longest = p.sides[jLongest]
+	shortfall = longest
+	for j, side := range p.sides {
+		if j == jLongest {
+			continue
+		}
+		if side > shortfall {
+			return longest, 0, true
+		}
+		shortfall -= side
+	}
+	return longest, shortfall, false
+}
+
+func (p Polygon) shape() PolygonShape {
+	if len(p.sides) < 3 {
+		return PolygonTooFewSides
+	}
+	_, shortfall, exceeds := p.longestSide()
+	if exceeds {
+		return PolygonValid
+	} else if shortfall == 0 {
+		return PolygonDegenerate
+	}
+	return PolygonImpossible
+}
+
+func (p Polygon) valid() bool {
+	return p.shape() == PolygonValid
+}
+
+func (p Polygon) degenerate() bool {
+	return p.shape() == PolygonDegenerate
+}
+
+// readPolygonSides parses whitespace-separated side lengths, one polygon per
+// line. The number of columns is taken from the first non-blank line, and
+// every other line must match it.
+func readPolygonSides(dump string) ([][]uint, error) {
+	rows := make([][]uint, 0)
+	nColumns := 0
+	for jline, line := range strings.Split(dump, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if nColumns == 0 {
+			nColumns = len(fields)
+		} else if len(fields) != nColumns {
+			return nil, fmt.Errorf("line %d: expected %d columns, found %d", jline+1, nColumns, len(fields))
+		}
+		row := make([]uint, nColumns)
+		for k, field := range fields {
+			length, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: column %d: %v", jline+1, k+1, err)
+			}
+			row[k] = uint(length)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// polygonsByRow treats each row as one polygon.
+func polygonsByRow(rows [][]uint) []Polygon {
+	polygons := make([]Polygon, len(rows))
+	for j, row := range rows {
+		polygons[j] = Polygon{row}
+	}
+	return polygons
+}
+
+// polygonsByColumn reads nSides consecutive rows down each column as one
+// polygon, like star 2 does for triangles. Leftover rows are ignored, and
+// nSides below 1 gives no polygons.
+func polygonsByColumn(rows [][]uint, nSides int) []Polygon {
+	polygons := make([]Polygon, 0, len(rows))
+	if len(rows) == 0 || nSides < 1 {
+		return polygons
+	}
+	for j := 0; j < len(rows[0]); j++ {
+		for k := 0; k+nSides <= len(rows); k += nSides {
+			sides := make([]uint, nSides)
+			for n := 0; n < nSides; n++ {
+				sides[n] = rows[k+n][j]
+			}
+			polygons = append(polygons, Polygon{sides})
+		}
+	}
+	return polygons
+}
+
 var _ = Describe("Day3", func() {
 	Describe("Triangle", func() {
 		It("detects valid triangles", func() {
@@ -37,6 +174,77 @@ var _ = Describe("Day3", func() {
 		})
 	})
 
+	Describe("Polygon", func() {
+		It("agrees with Triangle", func() {
+			Expect(Triangle{3, 4, 5}.polygon().valid()).To(BeTrue())
+			Expect(Triangle{5, 10, 25}.polygon().valid()).To(BeFalse())
+		})
+
+		It("detects valid polygons", func() {
+			Expect(Polygon{[]uint{1, 1, 1, 1}}.valid()).To(BeTrue())
+			Expect(Polygon{[]uint{2, 3, 4, 8}}.valid()).To(BeTrue())
+			Expect(Polygon{[]uint{8, 2, 3, 4}}.valid()).To(BeTrue())
+			Expect(Polygon{[]uint{1, 2, 3, 4, 5}}.valid()).To(BeTrue())
+		})
+
+		It("detects invalid polygons", func() {
+			Expect(Polygon{[]uint{2, 3, 4, 10}}.valid()).To(BeFalse())
+			Expect(Polygon{[]uint{10, 2, 3, 4}}.valid()).To(BeFalse())
+			Expect(Polygon{[]uint{1, 1, 1, 1, 5}}.valid()).To(BeFalse())
+		})
+
+		It("reports the shape", func() {
+			Expect(Polygon{[]uint{2, 3, 4, 8}}.shape()).To(Equal(PolygonValid))
+			Expect(Polygon{[]uint{2, 3, 4, 9}}.shape()).To(Equal(PolygonDegenerate))
+			Expect(Polygon{[]uint{2, 3, 4, 10}}.shape()).To(Equal(PolygonImpossible))
+			Expect(Polygon{[]uint{3, 4}}.shape()).To(Equal(PolygonTooFewSides))
+			Expect(Polygon{[]uint{0, 0, 0}}.degenerate()).To(BeTrue())
+			Expect(PolygonDegenerate.String()).To(Equal("degenerate"))
+		})
+
+		It("handles sides too long to sum", func() {
+			max := ^uint(0)
+			Expect(Polygon{[]uint{max, max, 5}}.shape()).To(Equal(PolygonValid))
+			Expect(Polygon{[]uint{max, max - 5, 5}}.shape()).To(Equal(PolygonDegenerate))
+			Expect(Polygon{[]uint{max, max - 6, 5}}.shape()).To(Equal(PolygonImpossible))
+			Expect(Polygon{[]uint{5, max, max / 2, max / 2}}.shape()).To(Equal(PolygonValid))
+		})
+
+		Describe("readPolygonSides", func() {
+			It("reads any number of columns", func() {
+				rows, err := readPolygonSides("  1  2  3  4\n\n 10 20 30 40\n")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rows).To(Equal([][]uint{{1, 2, 3, 4}, {10, 20, 30, 40}}))
+			})
+
+			It("complains about ragged rows", func() {
+				_, err := readPolygonSides("1 2 3 4 5\n1 2 3 4\n")
+				Expect(err).To(MatchError("line 2: expected 5 columns, found 4"))
+			})
+
+			It("complains about non-numeric sides", func() {
+				_, err := readPolygonSides("1 2 x\n")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		Describe("polygonsByColumn", func() {
+			It("groups consecutive rows down each column", func() {
+				rows := [][]uint{{1, 5}, {2, 6}, {3, 7}, {4, 8}}
+				Expect(polygonsByColumn(rows, 4)).To(Equal([]Polygon{
+					Polygon{[]uint{1, 2, 3, 4}},
+					Polygon{[]uint{5, 6, 7, 8}},
+				}))
+			})
+
+			It("gives no polygons for fewer than one side", func() {
+				rows := [][]uint{{1, 5}, {2, 6}}
+				Expect(polygonsByColumn(rows, 0)).To(BeEmpty())
+				Expect(polygonsByColumn(rows, -1)).To(BeEmpty())
+			})
+		})
+	})
+
 	Describe("the puzzle", func() {
 		puzzle_dump := `  883  357  185
   572  189  424
@@ -1,9 +1,14 @@
 package adventofcode2016_test
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"io"
 	"io/ioutil"
 	"math"
 	"regexp"
@@ -12,40 +17,218 @@ import (
 	"strings"
 )
 
-type SortableNameComponent struct {
-	element     byte
+// ----------------------------------------
+// frequency counting, shared with day 6
+
+type FrequencyEntry struct {
+	element     rune
 	occurrences uint
 }
 
-type SortableNameComponents []SortableNameComponent
+type FrequencyCounter struct {
+	counts   map[rune]uint
+	tieBreak func(a, b rune) bool // orders elements with equal occurrences
+}
+
+func ascendingRunes(a, b rune) bool {
+	return a < b
+}
+
+func NewFrequencyCounter() *FrequencyCounter {
+	return &FrequencyCounter{make(map[rune]uint), ascendingRunes}
+}
+
+func NewFrequencyCounterWithTieBreak(tieBreak func(a, b rune) bool) *FrequencyCounter {
+	return &FrequencyCounter{make(map[rune]uint), tieBreak}
+}
+
+func (fc *FrequencyCounter) AddN(element rune, n uint) {
+	fc.counts[element] += n
+}
+
+func (fc *FrequencyCounter) Add(element rune) {
+	fc.AddN(element, 1)
+}
+
+// AddBytes counts each byte on its own, regardless of encoding.
+func (fc *FrequencyCounter) AddBytes(elements []byte) {
+	for _, element := range elements {
+		fc.Add(rune(element))
+	}
+}
+
+// AddString counts each UTF-8 encoded rune.
+func (fc *FrequencyCounter) AddString(elements string) {
+	for _, element := range elements {
+		fc.Add(element)
+	}
+}
+
+func (fc *FrequencyCounter) Merge(other *FrequencyCounter) {
+	for element, occurrences := range other.counts {
+		fc.AddN(element, occurrences)
+	}
+}
+
+func (fc *FrequencyCounter) Count(element rune) uint {
+	return fc.counts[element]
+}
+
+// Len is the number of distinct elements seen.
+func (fc *FrequencyCounter) Len() int {
+	return len(fc.counts)
+}
+
+func (fc *FrequencyCounter) Total() uint {
+	total := uint(0)
+	for _, occurrences := range fc.counts {
+		total += occurrences
+	}
+	return total
+}
+
+type sortableFrequencyEntries struct {
+	entries  []FrequencyEntry
+	tieBreak func(a, b rune) bool
+}
+
+func (c sortableFrequencyEntries) Len() int { return len(c.entries) }
+func (c sortableFrequencyEntries) Swap(j, k int) {
+	c.entries[j], c.entries[k] = c.entries[k], c.entries[j]
+}
+func (c sortableFrequencyEntries) Less(j, k int) bool {
+	if c.entries[j].occurrences == c.entries[k].occurrences {
+		return c.tieBreak(c.entries[j].element, c.entries[k].element)
+	}
+	return c.entries[j].occurrences > c.entries[k].occurrences
+}
+
+// Sorted returns every entry, most common first.
+func (fc *FrequencyCounter) Sorted() []FrequencyEntry {
+	entries := make([]FrequencyEntry, 0, len(fc.counts))
+	for element, occurrences := range fc.counts {
+		entries = append(entries, FrequencyEntry{element, occurrences})
+	}
+	sort.Sort(sortableFrequencyEntries{entries, fc.tieBreak})
+	return entries
+}
+
+// TopN returns up to n entries, most common first; none for n <= 0.
+func (fc *FrequencyCounter) TopN(n int) []FrequencyEntry {
+	if n <= 0 {
+		return []FrequencyEntry{}
+	}
+	entries := fc.Sorted()
+	if n < len(entries) {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// BottomN returns up to n entries, least common first. It is the tail of
+// Sorted in reverse, so ties resolve the opposite way to TopN. There are
+// none for n <= 0.
+func (fc *FrequencyCounter) BottomN(n int) []FrequencyEntry {
+	if n <= 0 {
+		return []FrequencyEntry{}
+	}
+	entries := fc.Sorted()
+	bottom := make([]FrequencyEntry, 0, n)
+	for j := len(entries) - 1; j >= 0 && len(bottom) < n; j-- {
+		bottom = append(bottom, entries[j])
+	}
+	return bottom
+}
+
+// ----------------------------------------
+// room alphabets
+
+type RoomAlphabet struct {
+	letters []rune
+	index   map[rune]int // letter → position in the alphabet
+}
+
+func NewRoomAlphabet(letters string) *RoomAlphabet {
+	alphabet := &RoomAlphabet{[]rune(letters), make(map[rune]int)}
+	for j, letter := range alphabet.letters {
+		alphabet.index[letter] = j
+	}
+	return alphabet
+}
+
+var latinRoomAlphabet = NewRoomAlphabet("abcdefghijklmnopqrstuvwxyz")
+var polishRoomAlphabet = NewRoomAlphabet("aąbcćdeęfghijklłmnńoóprsśtuwyzźż")
+var cyrillicRoomAlphabet = NewRoomAlphabet("абвгдеёжзийклмнопрстуфхцчшщъыьэюя")
+
+func (a *RoomAlphabet) contains(letter rune) bool {
+	_, ok := a.index[letter]
+	return ok
+}
+
+// rotate shifts each letter forward by `shift` modulo the alphabet size.
+// Anything outside the alphabet is left alone.
+func (a *RoomAlphabet) rotate(text string, shift int) string {
+	modulus := len(a.letters)
+	rotated := []rune(text)
+	for j, letter := range rotated {
+		if position, ok := a.index[letter]; ok {
+			rotated[j] = a.letters[((position+shift)%modulus+modulus)%modulus]
+		}
+	}
+	return string(rotated)
+}
+
+func (a *RoomAlphabet) decrypt(name string, sectorID int) string {
+	return strings.Replace(a.rotate(name, sectorID), string(roomNameIgnore), " ", -1)
+}
+
+// checksum is the five most common letters, with ties broken by alphabet
+// order rather than by code point. Letters outside the alphabet aren't
+// counted, just as rotate leaves them alone.
+func (a *RoomAlphabet) checksum(name string) string {
+	counter := NewFrequencyCounterWithTieBreak(func(x, y rune) bool {
+		return a.index[x] < a.index[y]
+	})
+	for _, letter := range name {
+		if a.contains(letter) {
+			counter.Add(letter)
+		}
+	}
 
-func (c SortableNameComponents) Len() int      { return len(c) }
-func (c SortableNameComponents) Swap(j, k int) { c[j], c[k] = c[k], c[j] }
-func (c SortableNameComponents) Less(j, k int) bool {
-	if c[j].occurrences == c[k].occurrences {
-		return c[j].element < c[k].element
+	// assemble the string
+	rval := make([]rune, 0, 5)
+	for _, entry := range counter.TopN(5) {
+		rval = append(rval, entry.element)
 	}
-	return c[j].occurrences > c[k].occurrences
+	return string(rval)
 }
 
+// ----------------------------------------
+// rooms
+
 type Room struct {
 	descriptor string
+	alphabet   *RoomAlphabet
 }
 
 var roomSectorIdRe = regexp.MustCompile(`-(\d+)\[`)
-var roomNameRe = regexp.MustCompile(`([-a-z]+)-\d`)
-var roomNameIgnore = "-"[0]
+var roomNameRe = regexp.MustCompile(`([-\p{L}]+)-\d`)
+var roomNameIgnore = '-'
 var roomDescribedChecksumRe = regexp.MustCompile(`\[(.*)\]`)
 var blankStringRe = regexp.MustCompile(`^\s*$`)
 
 func NewRoom(descriptor string) *Room {
-	return &Room{descriptor}
+	return &Room{descriptor, latinRoomAlphabet}
+}
+
+func NewRoomWithAlphabet(descriptor string, alphabet *RoomAlphabet) *Room {
+	return &Room{descriptor, alphabet}
 }
 
 func (r Room) sectorID() int {
 	match := roomSectorIdRe.FindStringSubmatch(r.descriptor)
-	sectorId, _ := strconv.ParseInt(match[1], 10, 16)
-	return int(sectorId)
+	sectorId, _ := strconv.Atoi(match[1])
+	return sectorId
 }
 
 func (r Room) name() string {
@@ -63,50 +246,407 @@ func (r Room) valid() bool {
 }
 
 func (r Room) nameChecksum() string {
-	// build a map
-	byteCount := make(map[byte]uint)
-	for _, char := range []byte(r.name()) {
-		if char != roomNameIgnore {
-			if current, ok := byteCount[char]; ok {
-				byteCount[char] = current + 1
-			} else {
-				byteCount[char] = 1
+	return r.alphabet.checksum(r.name())
+}
+
+func (r Room) decryptedName() string {
+	return r.alphabet.decrypt(r.name(), r.sectorID())
+}
+
+// ----------------------------------------
+// parse-once room descriptors
+
+type ParsedRoom struct {
+	name              string
+	sectorID          int
+	describedChecksum string
+	alphabet          *RoomAlphabet
+}
+
+type RoomParseError struct {
+	descriptor string
+	field      string // "name", "sectorID" or "checksum"
+	offset     int    // byte offset into the descriptor
+	reason     string
+}
+
+func (e RoomParseError) Error() string {
+	return fmt.Sprintf("room `%s`: %s at offset %d: %s", e.descriptor, e.field, e.offset, e.reason)
+}
+
+func ParseRoom(descriptor string) (*ParsedRoom, error) {
+	return ParseRoomWithAlphabet(descriptor, latinRoomAlphabet)
+}
+
+func ParseRoomWithAlphabet(descriptor string, alphabet *RoomAlphabet) (*ParsedRoom, error) {
+	fail := func(field string, offset int, reason string, args ...interface{}) (*ParsedRoom, error) {
+		return nil, RoomParseError{descriptor, field, offset, fmt.Sprintf(reason, args...)}
+	}
+
+	// checksum: trailing [xxxxx]
+	open := strings.LastIndexByte(descriptor, '[')
+	if open < 0 {
+		return fail("checksum", len(descriptor), "missing `[`")
+	}
+	if !strings.HasSuffix(descriptor, "]") {
+		return fail("checksum", len(descriptor), "missing `]`")
+	}
+	checksum := descriptor[open+1 : len(descriptor)-1]
+	if len(checksum) == 0 {
+		return fail("checksum", open+1, "is empty")
+	}
+	for j, letter := range checksum {
+		if !alphabet.contains(letter) {
+			return fail("checksum", open+1+j, "`%c` is not a lowercase letter", letter)
+		}
+	}
+
+	// sector ID: digits after the last dash
+	dash := strings.LastIndexByte(descriptor[:open], '-')
+	if dash < 0 {
+		return fail("sectorID", 0, "missing `-` before sector ID")
+	}
+	sector := descriptor[dash+1 : open]
+	if len(sector) == 0 {
+		return fail("sectorID", dash+1, "is empty")
+	}
+	for j, digit := range sector {
+		if digit < '0' || digit > '9' {
+			return fail("sectorID", dash+1+j, "`%c` is not a digit", digit)
+		}
+	}
+	sectorID, err := strconv.ParseInt(sector, 10, 32)
+	if err != nil {
+		return fail("sectorID", dash+1, "`%s` is too large", sector)
+	}
+
+	// name: dash-separated runs of lowercase letters
+	name := descriptor[:dash]
+	if len(name) == 0 {
+		return fail("name", 0, "is empty")
+	}
+	previous := roomNameIgnore
+	for j, letter := range name {
+		if letter == roomNameIgnore {
+			if previous == roomNameIgnore {
+				return fail("name", j, "has an empty word")
 			}
+		} else if !alphabet.contains(letter) {
+			return fail("name", j, "`%c` is not a lowercase letter", letter)
 		}
+		previous = letter
+	}
+	if previous == roomNameIgnore {
+		return fail("name", dash-1, "has an empty word")
 	}
 
-	// convert to an array of SortableNameComponent
-	components := make(SortableNameComponents, 0, len(byteCount))
-	for element, occurrences := range byteCount {
-		components = append(components, SortableNameComponent{element, occurrences})
+	return &ParsedRoom{name, int(sectorID), checksum, alphabet}, nil
+}
+
+func (r ParsedRoom) nameChecksum() string {
+	return r.alphabet.checksum(r.name)
+}
+
+func (r ParsedRoom) valid() bool {
+	return r.describedChecksum == r.nameChecksum()
+}
+
+func (r ParsedRoom) decryptedName() string {
+	return r.alphabet.decrypt(r.name, r.sectorID)
+}
+
+// ----------------------------------------
+// shift cipher cracking, for when the sector ID is unknown
+
+// relative frequency of a–z in English text
+var englishLetterFrequency = [26]float64{
+	0.0817, 0.0149, 0.0278, 0.0425, 0.1270, 0.0223, 0.0202, 0.0609, 0.0697,
+	0.0015, 0.0077, 0.0403, 0.0241, 0.0675, 0.0751, 0.0193, 0.0010, 0.0599,
+	0.0633, 0.0906, 0.0276, 0.0098, 0.0236, 0.0015, 0.0197, 0.0007,
+}
+
+// a small dictionary of common English words, plus the vocabulary of the
+// Easter Bunny's room names
+var shiftCrackerDictionary = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "from": true, "have": true,
+	"he": true, "i": true, "in": true, "is": true, "it": true, "not": true,
+	"of": true, "on": true, "or": true, "she": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "we": true, "with": true, "you": true,
+	"north": true, "pole": true, "northpole": true, "object": true, "storage": true,
+	"easter": true, "bunny": true, "egg": true, "eggs": true, "candy": true,
+	"chocolate": true, "rabbit": true, "basket": true, "flower": true,
+	"radioactive": true, "scavenger": true, "hunt": true, "research": true,
+	"department": true, "design": true, "laboratory": true, "fuzzy": true,
+	"dye": true, "jellybean": true, "cryogenic": true, "magnetic": true,
+	"classified": true, "military": true, "weaponized": true, "grass": true,
+	"plastic": true, "biohazard": true, "colorful": true, "technology": true,
+	"projects": true, "deployment": true, "purchasing": true, "shipping": true,
+	"workshop": true, "engineering": true, "containment": true, "unit": true,
+	"training": true, "center": true, "reacher": true, "sales": true,
+}
+
+// each letter inside a dictionary word is worth this much extra score
+const shiftCrackerDictionaryBonus = 2.0
+
+type ShiftCandidate struct {
+	shift      int // rotation applied to the ciphertext to produce the plaintext
+	plaintext  string
+	score      float64 // log-likelihood plus dictionary bonus; higher is better
+	confidence float64 // share of the probability mass across all 26 rotations
+}
+
+// shiftText rotates ASCII letters forward by `shift`, preserving case and
+// leaving everything else alone.
+func shiftText(text string, shift int) string {
+	shift = ((shift % 26) + 26) % 26
+	shifted := []byte(text)
+	for j, char := range shifted {
+		if char >= 'a' && char <= 'z' {
+			shifted[j] = 'a' + (char-'a'+byte(shift))%26
+		} else if char >= 'A' && char <= 'Z' {
+			shifted[j] = 'A' + (char-'A'+byte(shift))%26
+		}
 	}
-	sort.Sort(components)
+	return string(shifted)
+}
 
-	// assemble the string
-	rval := make([]byte, 0, len(components))
-	for _, component := range components {
-		rval = append(rval, component.element)
+func scoreEnglish(text string) float64 {
+	score := 0.0
+	for _, char := range []byte(strings.ToLower(text)) {
+		if char >= 'a' && char <= 'z' {
+			score += math.Log(englishLetterFrequency[char-'a'])
+		}
 	}
-	return string(rval[0:5])
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return r < 'a' || r > 'z'
+	})
+	for _, word := range words {
+		if shiftCrackerDictionary[word] {
+			score += shiftCrackerDictionaryBonus * float64(len(word))
+		}
+	}
+	return score
 }
 
-func (r Room) decryptedName() string {
-	name := r.name()
-	sectorID := r.sectorID()
-	zero := "a"[0]
-	decryptedName := make([]byte, len(name))
-	for j := 0; j < len(name); j++ {
-		if name[j] == roomNameIgnore {
-			decryptedName[j] = " "[0]
+type ShiftCandidates []ShiftCandidate
+
+func (c ShiftCandidates) Len() int           { return len(c) }
+func (c ShiftCandidates) Swap(j, k int)      { c[j], c[k] = c[k], c[j] }
+func (c ShiftCandidates) Less(j, k int) bool { return c[j].score > c[k].score }
+
+// crackShiftCipher tries all 26 rotations of the ciphertext and returns them
+// ranked from most to least likely to be English.
+func crackShiftCipher(ciphertext string) ShiftCandidates {
+	candidates := make(ShiftCandidates, 26)
+	best := math.Inf(-1)
+	for shift := 0; shift < 26; shift++ {
+		plaintext := shiftText(ciphertext, shift)
+		candidates[shift] = ShiftCandidate{shift, plaintext, scoreEnglish(plaintext), 0}
+		best = math.Max(best, candidates[shift].score)
+	}
+
+	total := 0.0
+	for j := range candidates {
+		candidates[j].confidence = math.Exp(candidates[j].score - best)
+		total += candidates[j].confidence
+	}
+	for j := range candidates {
+		candidates[j].confidence /= total
+	}
+
+	sort.Stable(candidates)
+	return candidates
+}
+
+// crackRoomName cracks an encrypted room name, like "qzmt-zixmtkozy-ivhz".
+func crackRoomName(name string) ShiftCandidates {
+	return crackShiftCipher(strings.Replace(name, "-", " ", -1))
+}
+
+// ----------------------------------------
+// room descriptor encoding, the inverse of decryptedName
+
+func encryptRoomName(plaintext string, sectorID int, alphabet *RoomAlphabet) (string, error) {
+	words := strings.Fields(plaintext)
+	if len(words) == 0 {
+		return "", fmt.Errorf("cannot encrypt an empty room name")
+	}
+	for _, word := range words {
+		for _, letter := range word {
+			if !alphabet.contains(letter) {
+				return "", fmt.Errorf("cannot encrypt `%s`: `%c` is not a lowercase letter", plaintext, letter)
+			}
+		}
+	}
+	return alphabet.rotate(strings.Join(words, string(roomNameIgnore)), -sectorID), nil
+}
+
+// encodeRoom builds a full descriptor like "qzmt-zixmtkozy-ivhz-343[zimth]".
+// When decoy is true the checksum is deliberately wrong, for exercising
+// validators.
+func encodeRoom(plaintext string, sectorID int, decoy bool) (string, error) {
+	return encodeRoomWithAlphabet(plaintext, sectorID, decoy, latinRoomAlphabet)
+}
+
+func encodeRoomWithAlphabet(plaintext string, sectorID int, decoy bool, alphabet *RoomAlphabet) (string, error) {
+	if sectorID < 0 {
+		return "", fmt.Errorf("sector ID %d is negative", sectorID)
+	}
+	name, err := encryptRoomName(plaintext, sectorID, alphabet)
+	if err != nil {
+		return "", err
+	}
+	checksum := alphabet.checksum(name)
+	if decoy {
+		checksum = alphabet.rotate(checksum, 1)
+	}
+	return fmt.Sprintf("%s-%d[%s]", name, sectorID, checksum), nil
+}
+
+// ----------------------------------------
+// batch validation reports
+
+type RoomReportRow struct {
+	Descriptor        string `json:"descriptor"`
+	Name              string `json:"name"`
+	SectorID          int    `json:"sector_id"`
+	DescribedChecksum string `json:"described_checksum"`
+	ComputedChecksum  string `json:"computed_checksum"`
+	Valid             bool   `json:"valid"`
+	DecryptedName     string `json:"decrypted_name"`
+	Error             string `json:"error,omitempty"`
+}
+
+var roomReportCSVHeader = []string{
+	"descriptor", "name", "sector_id", "described_checksum", "computed_checksum",
+	"valid", "decrypted_name", "error",
+}
+
+// buildRoomReport reads day4.txt-style descriptors, one per line, and
+// returns a row for each. Descriptors that don't parse get a row with the
+// error filled in. When mismatchesOnly is true, valid rooms are left out.
+func buildRoomReport(input io.Reader, mismatchesOnly bool) ([]RoomReportRow, error) {
+	rows := make([]RoomReportRow, 0)
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		row := RoomReportRow{Descriptor: line}
+		if room, err := ParseRoom(line); err != nil {
+			row.Error = err.Error()
 		} else {
-			decryptedName[j] = byte(math.Mod(float64(int(name[j]-zero)+sectorID),
-				float64(26))) + zero
+			row.Name = room.name
+			row.SectorID = room.sectorID
+			row.DescribedChecksum = room.describedChecksum
+			row.ComputedChecksum = room.nameChecksum()
+			row.Valid = room.valid()
+			row.DecryptedName = room.decryptedName()
 		}
+
+		if mismatchesOnly && row.Valid {
+			continue
+		}
+		rows = append(rows, row)
+	}
+	return rows, scanner.Err()
+}
+
+func writeRoomReportCSV(output io.Writer, rows []RoomReportRow) error {
+	writer := csv.NewWriter(output)
+	writer.Write(roomReportCSVHeader)
+	for _, row := range rows {
+		writer.Write([]string{
+			row.Descriptor,
+			row.Name,
+			strconv.Itoa(row.SectorID),
+			row.DescribedChecksum,
+			row.ComputedChecksum,
+			strconv.FormatBool(row.Valid),
+			row.DecryptedName,
+			row.Error,
+		})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func writeRoomReportJSON(output io.Writer, rows []RoomReportRow) error {
+	encoder := json.NewEncoder(output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rows)
+}
+
+func writeRoomReport(output io.Writer, rows []RoomReportRow, format string) error {
+	switch format {
+	case "csv":
+		return writeRoomReportCSV(output, rows)
+	case "json":
+		return writeRoomReportJSON(output, rows)
 	}
-	return string(decryptedName)
+	return fmt.Errorf("unknown report format `%s`", format)
 }
 
 var _ = Describe("Day4", func() {
+	Describe("FrequencyCounter", func() {
+		It("orders by occurrences, then by element", func() {
+			counter := NewFrequencyCounter()
+			counter.AddString("abbcccdddeee")
+			Expect(counter.Sorted()).To(Equal([]FrequencyEntry{
+				{'c', 3}, {'d', 3}, {'e', 3}, {'b', 2}, {'a', 1},
+			}))
+			Expect(counter.Len()).To(Equal(5))
+			Expect(counter.Total()).To(Equal(uint(12)))
+		})
+
+		It("answers top-N and bottom-N queries", func() {
+			counter := NewFrequencyCounter()
+			counter.AddBytes([]byte("abbcccdddeee"))
+			Expect(counter.TopN(2)).To(Equal([]FrequencyEntry{{'c', 3}, {'d', 3}}))
+			Expect(counter.BottomN(2)).To(Equal([]FrequencyEntry{{'a', 1}, {'b', 2}}))
+			Expect(counter.TopN(10)).To(HaveLen(5))
+			Expect(counter.BottomN(10)).To(HaveLen(5))
+			Expect(counter.TopN(0)).To(BeEmpty())
+			Expect(counter.TopN(-1)).To(BeEmpty())
+			Expect(counter.BottomN(0)).To(BeEmpty())
+			Expect(counter.BottomN(-1)).To(BeEmpty())
+		})
+
+		It("uses a configurable tie-break", func() {
+			counter := NewFrequencyCounterWithTieBreak(func(a, b rune) bool { return a > b })
+			counter.AddString("abcabc")
+			Expect(counter.TopN(3)).To(Equal([]FrequencyEntry{{'c', 2}, {'b', 2}, {'a', 2}}))
+		})
+
+		It("merges and updates incrementally", func() {
+			counter1 := NewFrequencyCounter()
+			counter1.AddString("aab")
+			counter2 := NewFrequencyCounter()
+			counter2.AddString("bbc")
+			counter1.Merge(counter2)
+			Expect(counter1.Count('a')).To(Equal(uint(2)))
+			Expect(counter1.Count('b')).To(Equal(uint(3)))
+			Expect(counter1.Count('c')).To(Equal(uint(1)))
+			Expect(counter1.Count('z')).To(Equal(uint(0)))
+
+			counter1.AddN('c', 5)
+			Expect(counter1.TopN(1)).To(Equal([]FrequencyEntry{{'c', 6}}))
+		})
+
+		It("counts runes in strings and bytes in byte slices", func() {
+			counter := NewFrequencyCounter()
+			counter.AddString("ññ")
+			Expect(counter.Count('ñ')).To(Equal(uint(2)))
+
+			counter = NewFrequencyCounter()
+			counter.AddBytes([]byte("ñ"))
+			Expect(counter.Len()).To(Equal(2))
+		})
+	})
+
 	Describe("Room", func() {
 		room1 := NewRoom("aaaaa-bbb-z-y-x-123[abxyz]")
 		room2 := NewRoom("a-b-c-d-e-f-g-h-987[abcde]")
@@ -157,6 +697,229 @@ var _ = Describe("Day4", func() {
 		})
 	})
 
+	Describe("ParsedRoom", func() {
+		It("parses every field once", func() {
+			room, err := ParseRoom("not-a-real-room-404[oarel]")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*room).To(Equal(ParsedRoom{"not-a-real-room", 404, "oarel", latinRoomAlphabet}))
+			Expect(room.valid()).To(BeTrue())
+		})
+
+		It("agrees with Room", func() {
+			for _, descriptor := range []string{
+				"aaaaa-bbb-z-y-x-123[abxyz]",
+				"a-b-c-d-e-f-g-h-987[abcde]",
+				"totally-real-room-200[decoy]",
+				"qzmt-zixmtkozy-ivhz-343[asdf]",
+			} {
+				room, err := ParseRoom(descriptor)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(room.valid()).To(Equal(NewRoom(descriptor).valid()), descriptor)
+				Expect(room.decryptedName()).To(Equal(NewRoom(descriptor).decryptedName()), descriptor)
+			}
+		})
+
+		It("handles sector IDs that don't fit in 16 bits", func() {
+			room, err := ParseRoom("abc-40000[abc]")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(room.sectorID).To(Equal(40000))
+			Expect(NewRoom("abc-40000[abc]").sectorID()).To(Equal(40000))
+		})
+
+		It("reports missing brackets", func() {
+			_, err := ParseRoom("abc-123abc]")
+			Expect(err).To(MatchError("room `abc-123abc]`: checksum at offset 11: missing `[`"))
+
+			_, err = ParseRoom("abc-123[abc")
+			Expect(err).To(MatchError("room `abc-123[abc`: checksum at offset 11: missing `]`"))
+		})
+
+		It("reports non-alpha names", func() {
+			_, err := ParseRoom("ab3c-123[abc]")
+			Expect(err).To(MatchError("room `ab3c-123[abc]`: name at offset 2: `3` is not a lowercase letter"))
+			Expect(err.(RoomParseError).field).To(Equal("name"))
+
+			_, err = ParseRoom("ab--c-123[abc]")
+			Expect(err).To(MatchError("room `ab--c-123[abc]`: name at offset 3: has an empty word"))
+		})
+
+		It("reports oversized sectors", func() {
+			_, err := ParseRoom("abc-99999999999[abc]")
+			Expect(err).To(MatchError("room `abc-99999999999[abc]`: sectorID at offset 4: `99999999999` is too large"))
+		})
+	})
+
+	Describe("RoomAlphabet", func() {
+		It("rotates modulo the alphabet size", func() {
+			Expect(latinRoomAlphabet.rotate("xyz-abc", 3)).To(Equal("abc-def"))
+			Expect(latinRoomAlphabet.rotate("abc", -1)).To(Equal("zab"))
+			Expect(cyrillicRoomAlphabet.rotate("яёж", 1)).To(Equal("ажз"))
+			Expect(cyrillicRoomAlphabet.rotate("абв", 33)).To(Equal("абв"))
+			Expect(polishRoomAlphabet.rotate("aąż", 1)).To(Equal("ąba"))
+		})
+
+		It("breaks checksum ties by alphabet order", func() {
+			// ё is U+0451, after я in code points but seventh in the alphabet
+			Expect(cyrillicRoomAlphabet.checksum("я-ё-ж-а-б")).To(Equal("абёжя"))
+			Expect(cyrillicRoomAlphabet.checksum("ёё-яя-ж")).To(Equal("ёяж"))
+		})
+
+		It("leaves letters outside the alphabet out of the checksum", func() {
+			Expect(NewRoom("éàü-éàü-ab-123[abcde]").nameChecksum()).To(Equal("ab"))
+			Expect(NewRoom("éàü-éàü-ab-123[abcde]").valid()).To(BeFalse())
+			Expect(NewRoom("éàü-éàü-ab-123[ab]").valid()).To(BeTrue())
+		})
+
+		It("handles Cyrillic rooms end to end", func() {
+			descriptor, err := encodeRoomWithAlphabet("северный полюс", 100, false, cyrillicRoomAlphabet)
+			Expect(err).NotTo(HaveOccurred())
+
+			room := NewRoomWithAlphabet(descriptor, cyrillicRoomAlphabet)
+			Expect(room.valid()).To(BeTrue())
+			Expect(room.sectorID()).To(Equal(100))
+			Expect(room.decryptedName()).To(Equal("северный полюс"))
+
+			parsed, err := ParseRoomWithAlphabet(descriptor, cyrillicRoomAlphabet)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(parsed.valid()).To(BeTrue())
+			Expect(parsed.decryptedName()).To(Equal("северный полюс"))
+		})
+
+		It("reports letters outside the alphabet with byte offsets", func() {
+			_, err := ParseRoomWithAlphabet("ёж-hи-1[ёж]", cyrillicRoomAlphabet)
+			Expect(err).To(MatchError("room `ёж-hи-1[ёж]`: name at offset 5: `h` is not a lowercase letter"))
+		})
+	})
+
+	Describe("crackShiftCipher", func() {
+		It("cracks room names without the sector ID", func() {
+			candidates := crackRoomName("qzmt-zixmtkozy-ivhz")
+			Expect(candidates).To(HaveLen(26))
+			Expect(candidates[0].plaintext).To(Equal("very encrypted name"))
+			Expect(candidates[0].shift).To(Equal(343 % 26))
+			Expect(candidates[0].confidence).To(BeNumerically(">", candidates[1].confidence))
+		})
+
+		It("agrees with decryptedName on a puzzle room", func() {
+			room := NewRoom("ghkmaihex-hucxvm-lmhktzx-501[hmxka]")
+			Expect(crackRoomName(room.name())[0].plaintext).To(Equal(room.decryptedName()))
+		})
+
+		It("cracks arbitrary Caesar-shifted text", func() {
+			// only "of" is in the dictionary, so this leans on letter frequencies
+			candidates := crackShiftCipher("Liabgq hy uetvd jntkms, cnwzx fr ohp!")
+			Expect(candidates[0].plaintext).To(Equal("Sphinx of black quartz, judge my vow!"))
+			Expect(candidates[0].shift).To(Equal(7))
+			Expect(candidates[0].confidence).To(BeNumerically(">", candidates[1].confidence))
+		})
+
+		It("ranks candidates and sums confidence to one", func() {
+			candidates := crackShiftCipher("wkh txlfn eurzq ira")
+			total := 0.0
+			for j, candidate := range candidates {
+				total += candidate.confidence
+				if j > 0 {
+					Expect(candidate.score).To(BeNumerically("<=", candidates[j-1].score))
+				}
+			}
+			Expect(total).To(BeNumerically("~", 1.0, 1e-9))
+			Expect(candidates[0].plaintext).To(Equal("the quick brown fox"))
+		})
+	})
+
+	Describe("encodeRoom", func() {
+		It("encrypts plaintext into a valid descriptor", func() {
+			descriptor, err := encodeRoom("very encrypted name", 343, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(descriptor).To(Equal("qzmt-zixmtkozy-ivhz-343[zimth]"))
+			Expect(NewRoom(descriptor).valid()).To(BeTrue())
+		})
+
+		It("round-trips through decryptedName", func() {
+			descriptor, err := encodeRoom("northpole object storage", 501, false)
+			Expect(err).NotTo(HaveOccurred())
+			room, err := ParseRoom(descriptor)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(room.valid()).To(BeTrue())
+			Expect(room.sectorID).To(Equal(501))
+			Expect(room.decryptedName()).To(Equal("northpole object storage"))
+		})
+
+		It("generates decoys with wrong checksums", func() {
+			descriptor, err := encodeRoom("northpole object storage", 501, true)
+			Expect(err).NotTo(HaveOccurred())
+			room, err := ParseRoom(descriptor)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(room.valid()).To(BeFalse())
+			Expect(room.decryptedName()).To(Equal("northpole object storage"))
+		})
+
+		It("rejects names it cannot encrypt", func() {
+			_, err := encodeRoom("north pole 9", 501, false)
+			Expect(err).To(MatchError("cannot encrypt `north pole 9`: `9` is not a lowercase letter"))
+
+			_, err = encodeRoom("   ", 501, false)
+			Expect(err).To(HaveOccurred())
+
+			_, err = encodeRoom("north pole", -1, false)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("room report", func() {
+		input := "aaaaa-bbb-z-y-x-123[abxyz]\n\ntotally-real-room-200[decoy]\nnot-a-room\n"
+
+		It("builds a row per descriptor", func() {
+			rows, err := buildRoomReport(strings.NewReader(input), false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rows).To(HaveLen(3))
+			Expect(rows[0]).To(Equal(RoomReportRow{
+				Descriptor:        "aaaaa-bbb-z-y-x-123[abxyz]",
+				Name:              "aaaaa-bbb-z-y-x",
+				SectorID:          123,
+				DescribedChecksum: "abxyz",
+				ComputedChecksum:  "abxyz",
+				Valid:             true,
+				DecryptedName:     "ttttt uuu s r q",
+			}))
+			Expect(rows[1].Valid).To(BeFalse())
+			Expect(rows[1].ComputedChecksum).To(Equal("loart"))
+			Expect(rows[2].Error).To(Equal("room `not-a-room`: checksum at offset 10: missing `[`"))
+		})
+
+		It("can show only mismatches", func() {
+			rows, err := buildRoomReport(strings.NewReader(input), true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rows).To(HaveLen(2))
+			Expect(rows[0].Descriptor).To(Equal("totally-real-room-200[decoy]"))
+			Expect(rows[1].Descriptor).To(Equal("not-a-room"))
+		})
+
+		It("writes CSV", func() {
+			rows, _ := buildRoomReport(strings.NewReader(input), true)
+			output := bytes.Buffer{}
+			Expect(writeRoomReport(&output, rows[:1], "csv")).To(Succeed())
+			Expect(output.String()).To(Equal(
+				"descriptor,name,sector_id,described_checksum,computed_checksum,valid,decrypted_name,error\n" +
+					"totally-real-room-200[decoy],totally-real-room,200,decoy,loart,false,lglsddq jwsd jgge,\n"))
+		})
+
+		It("writes JSON", func() {
+			rows, _ := buildRoomReport(strings.NewReader(input), true)
+			output := bytes.Buffer{}
+			Expect(writeRoomReport(&output, rows, "json")).To(Succeed())
+
+			decoded := []RoomReportRow{}
+			Expect(json.Unmarshal(output.Bytes(), &decoded)).To(Succeed())
+			Expect(decoded).To(Equal(rows))
+			Expect(output.String()).To(ContainSubstring(`"sector_id": 200`))
+		})
+
+		It("rejects unknown formats", func() {
+			Expect(writeRoomReport(&bytes.Buffer{}, nil, "xml")).To(MatchError("unknown report format `xml`"))
+		})
+	})
+
 	Describe("the puzzle", func() {
 		data, _ := ioutil.ReadFile("day4.txt")
 
@@ -173,6 +936,18 @@ var _ = Describe("Day4", func() {
 			fmt.Println("sum is ", sum)
 		})
 
+		It("star 1, from the report", func() {
+			rows, err := buildRoomReport(bytes.NewReader(data), false)
+			Expect(err).NotTo(HaveOccurred())
+			sum := 0
+			for _, row := range rows {
+				if row.Valid {
+					sum += row.SectorID
+				}
+			}
+			fmt.Println("sum is ", sum, "from", len(rows), "rooms")
+		})
+
 		It("star 2", func() {
 			for _, line := range strings.Split(string(data), "\n") {
 				if blankStringRe.MatchString(line) {
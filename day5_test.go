@@ -1,87 +1,1130 @@
 package adventofcode2016_test
 
 import (
+	"bytes"
 	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type Door struct {
-	id string
+// ----------------------------------------
+// hash algorithms, shared with day 14
+
+// Hasher produces a lowercase hex digest.
+type Hasher interface {
+	HexSum(input []byte) []byte
+}
+
+// HasherFunc adapts a function returning a raw digest into a Hasher.
+type HasherFunc func(input []byte) []byte
+
+func (f HasherFunc) HexSum(input []byte) []byte {
+	digest := f(input)
+	hexDigest := make([]byte, hex.EncodedLen(len(digest)))
+	hex.Encode(hexDigest, digest)
+	return hexDigest
+}
+
+// HashRules are the prefix and repeat rules the puzzles apply to hex digests.
+type HashRules struct {
+	doorPrefix string // a Door hash must start with this
+	keyRepeat  int    // a key candidate contains a run of this many (a "triple")
+	keyConfirm int    // which is confirmed by a run of this many (a "quintuple")
+}
+
+var defaultHashRules = HashRules{"00000", 3, 5}
+
+type HashAlgorithm struct {
+	name   string
+	hasher Hasher
+	rules  HashRules
+}
+
+var md5Algorithm = HashAlgorithm{"md5", HasherFunc(func(input []byte) []byte {
+	digest := md5.Sum(input)
+	return digest[:]
+}), defaultHashRules}
+
+var sha1Algorithm = HashAlgorithm{"sha1", HasherFunc(func(input []byte) []byte {
+	digest := sha1.Sum(input)
+	return digest[:]
+}), defaultHashRules}
+
+var sha256Algorithm = HashAlgorithm{"sha256", HasherFunc(func(input []byte) []byte {
+	digest := sha256.Sum256(input)
+	return digest[:]
+}), defaultHashRules}
+
+var hashAlgorithms = map[string]HashAlgorithm{
+	md5Algorithm.name:    md5Algorithm,
+	sha1Algorithm.name:   sha1Algorithm,
+	sha256Algorithm.name: sha256Algorithm,
+}
+
+func LookupHashAlgorithm(name string) (HashAlgorithm, error) {
+	algorithm, ok := hashAlgorithms[name]
+	if !ok {
+		return HashAlgorithm{}, fmt.Errorf("unknown hash algorithm `%s`", name)
+	}
+	return algorithm, nil
+}
+
+func (a HashAlgorithm) withRules(rules HashRules) HashAlgorithm {
+	return HashAlgorithm{a.name, a.hasher, rules}
+}
+
+func (a HashAlgorithm) hexSum(input string) string {
+	return string(a.hasher.HexSum([]byte(input)))
+}
+
+func md5sum(input string) string {
+	return md5Algorithm.hexSum(input)
+}
+
+// ----------------------------------------
+// doors
+
+// DoorSpec describes how a door turns hashes into a password.
+type DoorSpec struct {
+	passwordLen   int
+	difficulty    int // leading zero hex digits a hash needs
+	charIndex     int // hash character that password uses
+	positionIndex int // hash character that password2 uses as a position
+	valueIndex    int // hash character that password2 uses as the value
 }
 
 var passwordLen = 8
-var zeroByte = "0"[0]
-var eightByte = "8"[0]
+var defaultDoorSpec = DoorSpec{passwordLen, 5, 5, 5, 6}
+
+// specForPrefix reads the characters just after the prefix, like the puzzle.
+func specForPrefix(prefix string) DoorSpec {
+	difficulty := len(prefix)
+	return DoorSpec{passwordLen, difficulty, difficulty, difficulty, difficulty + 1}
+}
+
+type Door struct {
+	id      string
+	hashing HashAlgorithm
+	spec    DoorSpec
+	prefix  string // the algorithm's doorPrefix, or spec.difficulty zeros
+}
+
+func NewDoor(id string) Door {
+	door, _ := NewDoorWithSpec(id, md5Algorithm, defaultDoorSpec)
+	return door
+}
+
+// NewDoorWithHash matches the algorithm's doorPrefix, whatever its
+// characters, and reads the password from just after it.
+func NewDoorWithHash(id string, hashing HashAlgorithm) (Door, error) {
+	prefix := hashing.rules.doorPrefix
+	return newDoor(id, hashing, specForPrefix(prefix), prefix)
+}
+
+// NewDoorWithSpec matches spec.difficulty leading zeros.
+func NewDoorWithSpec(id string, hashing HashAlgorithm, spec DoorSpec) (Door, error) {
+	return newDoor(id, hashing, spec, strings.Repeat("0", spec.difficulty))
+}
+
+func newDoor(id string, hashing HashAlgorithm, spec DoorSpec, prefix string) (Door, error) {
+	door := Door{id, hashing, spec, prefix}
+	return door, door.validate()
+}
+
+// validate rules out specs that would read past the end of a digest, or
+// never finish: password2 positions are single hex digits, so a password
+// can't be longer than 16, and no hash has more leading zeros than digits.
+func (d Door) validate() error {
+	digestLen := len(d.hashing.hexSum(""))
+	if d.spec.passwordLen < 1 || d.spec.passwordLen > len(hexDigits) {
+		return fmt.Errorf("password length `%d` is outside 1 to %d", d.spec.passwordLen, len(hexDigits))
+	}
+	if d.spec.difficulty < 0 || d.spec.difficulty > digestLen {
+		return fmt.Errorf("difficulty `%d` is outside 0 to %d, the %s digest length", d.spec.difficulty, digestLen, d.hashing.name)
+	}
+	for _, index := range []struct {
+		name  string
+		value int
+	}{
+		{"character index", d.spec.charIndex},
+		{"position index", d.spec.positionIndex},
+		{"value index", d.spec.valueIndex},
+	} {
+		if index.value < 0 || index.value >= digestLen {
+			return fmt.Errorf("%s `%d` is outside the %d character %s digest", index.name, index.value, digestLen, d.hashing.name)
+		}
+	}
+	return nil
+}
+
 var spaceByte = byte(32)
 
-func md5sum(input string) string {
-	return fmt.Sprintf("%x", md5.Sum([]byte(input)))
+// hit hashes the id with the index, and reports whether the hash has enough
+// leading zeros.
+func (d Door) hit(index int) (string, bool) {
+	hash := d.hashing.hexSum(d.id + strconv.Itoa(index))
+	return hash, strings.HasPrefix(hash, d.prefix)
+}
+
+// DoorHit is an index whose hash has the door's prefix.
+type DoorHit struct {
+	index int
+	hash  string
+}
+
+// DoorHitStream yields hits in increasing index order.
+type DoorHitStream interface {
+	Next() DoorHit
+	Stop()
 }
 
 func (d Door) password() string {
-	password := make([]byte, passwordLen)
-	index := 0
-	for j := 0; j < passwordLen; j++ {
+	return d.passwordFromHits(NewSequentialDoorHits(d, 0))
+}
+
+func (d Door) password2() string {
+	return d.password2FromHits(NewSequentialDoorHits(d, 0), nil)
+}
+
+func (d Door) parallelPassword(workers int) string {
+	return d.passwordFromHits(NewParallelDoorMiner(d, 0, workers))
+}
+
+func (d Door) parallelPassword2(workers int) string {
+	return d.password2FromHits(NewParallelDoorMiner(d, 0, workers), nil)
+}
+
+func (d Door) blankPassword() []byte {
+	password := make([]byte, d.spec.passwordLen)
+	for j := range password {
+		password[j] = spaceByte
+	}
+	return password
+}
+
+func (d Door) passwordFromHits(hits DoorHitStream) string {
+	return d.resumePassword(d.blankPassword(), hits, nil)
+}
+
+// password2FromHits calls found (if not nil) as each position is filled in.
+func (d Door) password2FromHits(hits DoorHitStream, found func(position int, char byte)) string {
+	return d.resumePassword2(d.blankPassword(), hits, found)
+}
+
+// applyPasswordHit fills the first unknown position with the spec's
+// character.
+func (d Door) applyPasswordHit(password []byte, hash string) (int, bool) {
+	position := bytes.IndexByte(password, spaceByte)
+	if position < 0 {
+		return -1, false
+	}
+	password[position] = hash[d.spec.charIndex]
+	return position, true
+}
+
+// applyPassword2Hit reads a hex digit position and a value from the hash,
+// and uses them if the position is in range and still unknown.
+func (d Door) applyPassword2Hit(password []byte, hash string) (int, bool) {
+	position := strings.IndexByte(hexDigits, hash[d.spec.positionIndex])
+	if position >= 0 && position < len(password) && password[position] == spaceByte {
+		password[position] = hash[d.spec.valueIndex]
+		return position, true
+	}
+	return -1, false
+}
+
+// resumePassword fills in the unknown positions of a partial password.
+func (d Door) resumePassword(password []byte, hits DoorHitStream, found func(position int, char byte)) string {
+	return d.resumeWith(d.applyPasswordHit, password, hits, found)
+}
+
+func (d Door) resumePassword2(password []byte, hits DoorHitStream, found func(position int, char byte)) string {
+	return d.resumeWith(d.applyPassword2Hit, password, hits, found)
+}
+
+func (d Door) resumeWith(apply func([]byte, string) (int, bool), password []byte, hits DoorHitStream, found func(position int, char byte)) string {
+	defer hits.Stop()
+	for bytes.IndexByte(password, spaceByte) >= 0 {
+		if position, ok := apply(password, hits.Next().hash); ok && found != nil {
+			found(position, password[position])
+		}
+	}
+	return string(password)
+}
+
+// ----------------------------------------
+// sequential mining
+
+type SequentialDoorHits struct {
+	door     Door
+	index    int
+	progress func(index int) // if not nil, called every doorProgressInterval indices
+}
+
+var doorProgressInterval = 100000
+
+func NewSequentialDoorHits(door Door, start int) *SequentialDoorHits {
+	return &SequentialDoorHits{door, start, nil}
+}
+
+func (s *SequentialDoorHits) Next() DoorHit {
+	for {
+		if s.progress != nil && s.index%doorProgressInterval == 0 {
+			s.progress(s.index)
+		}
+		hash, ok := s.door.hit(s.index)
+		s.index++
+		if ok {
+			return DoorHit{s.index - 1, hash}
+		}
+	}
+}
+
+func (s *SequentialDoorHits) Stop() {}
+
+// ----------------------------------------
+// scanned mining, from a HashScanner (see day 14) that may be cached on disk
+
+type ScannedDoorHits struct {
+	scanner *HashScanner
+	index   int
+	err     error
+}
+
+func (d Door) scanner(cacheDir string) (*HashScanner, error) {
+	if d.prefix != strings.Repeat("0", d.spec.difficulty) {
+		return nil, fmt.Errorf("door prefix `%s` isn't leading zeros, which is all a HashScanner finds", d.prefix)
+	}
+	return NewHashScanner(d.id, 0, d.hashing, HashPatterns{leadingZeros: d.spec.difficulty}, cacheDir)
+}
+
+func NewScannedDoorHits(scanner *HashScanner, start int) *ScannedDoorHits {
+	return &ScannedDoorHits{scanner, start, nil}
+}
+
+func (s *ScannedDoorHits) Next() DoorHit {
+	event := s.scanner.NextEvent(s.index, LeadingZeros)
+	s.index = event.Index + 1
+	return DoorHit{event.Index, event.Hash}
+}
+
+// Stop saves the scan so far, if the scanner has a cache. Err reports
+// whether that save failed.
+func (s *ScannedDoorHits) Stop() {
+	s.err = s.scanner.Save()
+}
+
+func (s *ScannedDoorHits) Err() error {
+	return s.err
+}
+
+func (d Door) scannedPassword(cacheDir string) (string, error) {
+	scanner, err := d.scanner(cacheDir)
+	if err != nil {
+		return "", err
+	}
+	hits := NewScannedDoorHits(scanner, 0)
+	password := d.passwordFromHits(hits)
+	return password, hits.Err()
+}
+
+func (d Door) scannedPassword2(cacheDir string) (string, error) {
+	scanner, err := d.scanner(cacheDir)
+	if err != nil {
+		return "", err
+	}
+	hits := NewScannedDoorHits(scanner, 0)
+	password := d.password2FromHits(hits, nil)
+	return password, hits.Err()
+}
+
+// ----------------------------------------
+// mock hit stream
+
+type MockDoorHits struct {
+	hits    []DoorHit
+	stopped bool
+}
+
+func (m *MockDoorHits) Next() DoorHit {
+	hit := m.hits[0]
+	m.hits = m.hits[1:]
+	return hit
+}
+
+func (m *MockDoorHits) Stop() {
+	m.stopped = true
+}
+
+// ----------------------------------------
+// parallel mining
+//
+//  The index space is cut into batches of batchSize indices. Worker w
+//  mines batches w, w+workers, w+2*workers, ... and sends each batch's hits
+//  back tagged with the batch number, so Next can hand them out in index
+//  order no matter which worker finishes first.
+
+const doorMinerBatchSize = 1000
+
+type doorMinerBatch struct {
+	number int
+	hits   []DoorHit
+}
+
+type ParallelDoorMiner struct {
+	batchSize int
+	results   chan doorMinerBatch
+	stop      chan bool
+	workers   sync.WaitGroup
+	pending   map[int][]DoorHit // batch number → hits, for batches that arrived early
+	nextBatch int
+	queue     []DoorHit // hits from nextBatch-1 not yet handed out
+}
+
+func NewParallelDoorMiner(door Door, start, workers int) *ParallelDoorMiner {
+	return NewParallelDoorMinerWithBatchSize(door, start, workers, doorMinerBatchSize)
+}
+
+func NewParallelDoorMinerWithBatchSize(door Door, start, workers, batchSize int) *ParallelDoorMiner {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if batchSize <= 0 {
+		batchSize = doorMinerBatchSize
+	}
+	miner := &ParallelDoorMiner{
+		batchSize: batchSize,
+		results:   make(chan doorMinerBatch, workers*2),
+		stop:      make(chan bool),
+		pending:   make(map[int][]DoorHit),
+	}
+	miner.workers.Add(workers)
+	for w := 0; w < workers; w++ {
+		go miner.work(door, start, w, workers)
+	}
+	return miner
+}
+
+func (m *ParallelDoorMiner) work(door Door, start, worker, workers int) {
+	defer m.workers.Done()
+	for number := worker; ; number += workers {
+		hits := make([]DoorHit, 0)
+		first := start + number*m.batchSize
+		for index := first; index < first+m.batchSize; index++ {
+			if hash, ok := door.hit(index); ok {
+				hits = append(hits, DoorHit{index, hash})
+			}
+		}
+		select {
+		case m.results <- doorMinerBatch{number, hits}:
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+func (m *ParallelDoorMiner) Next() DoorHit {
+	for len(m.queue) == 0 {
 		for {
-			hash := md5sum(d.id + strconv.Itoa(index))
-			index++
-			if hash[0:5] == "00000" {
-				password[j] = hash[5]
+			if hits, ok := m.pending[m.nextBatch]; ok {
+				delete(m.pending, m.nextBatch)
+				m.queue = hits
 				break
 			}
+			batch := <-m.results
+			m.pending[batch.number] = batch.hits
 		}
+		m.nextBatch++
 	}
-	return string(password)
+	hit := m.queue[0]
+	m.queue = m.queue[1:]
+	return hit
 }
 
-func (d Door) password2() string {
-	password := []byte{spaceByte, spaceByte, spaceByte, spaceByte, spaceByte, spaceByte, spaceByte, spaceByte}
-	index := 0
-	for j := 0; j < passwordLen; j++ {
-		for {
-			hash := md5sum(d.id + strconv.Itoa(index))
-			index++
-			if hash[0:5] == "00000" &&
-				hash[5] >= zeroByte &&
-				hash[5] < eightByte {
-				position := hash[5] - zeroByte
-				if password[position] == spaceByte {
-					password[position] = hash[6]
-					break
-				}
+// Stop shuts down the workers, waiting for each to finish the batch it's
+// on. The miner can't be used afterwards.
+func (m *ParallelDoorMiner) Stop() {
+	close(m.stop)
+	m.workers.Wait()
+}
+
+// ----------------------------------------
+// checkpoints
+//
+//  A checkpoint is written every doorProgressInterval indices and whenever a
+//  position is found, so an interrupted run can pick up where it left off.
+//  Every hit before the checkpoint's index has already been applied.
+
+var doorCheckpointPath = flag.String("door-checkpoint", "", "write Day 5 mining checkpoints to this file")
+var doorResume = flag.Bool("door-resume", false, "resume Day 5 mining from -door-checkpoint")
+
+// DoorCheckpointSpec is a DoorSpec and the prefix it matches, exported for
+// JSON.
+type DoorCheckpointSpec struct {
+	Prefix        string `json:"prefix"`
+	PasswordLen   int    `json:"passwordLen"`
+	Difficulty    int    `json:"difficulty"`
+	CharIndex     int    `json:"charIndex"`
+	PositionIndex int    `json:"positionIndex"`
+	ValueIndex    int    `json:"valueIndex"`
+}
+
+// DoorCheckpoint records the algorithm and spec along with the door, since
+// resuming with either changed would carry on a different search.
+type DoorCheckpoint struct {
+	Door      string             `json:"door"`
+	Algorithm string             `json:"algorithm"`
+	Spec      DoorCheckpointSpec `json:"spec"`
+	Version   int                `json:"version"` // 1 for password, 2 for password2
+	Index     int                `json:"index"`   // next index to hash
+	Password  string             `json:"password"`
+}
+
+// newCheckpoint is a checkpoint for a run that hasn't started.
+func (d Door) newCheckpoint(version int) *DoorCheckpoint {
+	spec := DoorCheckpointSpec{d.prefix, d.spec.passwordLen, d.spec.difficulty, d.spec.charIndex, d.spec.positionIndex, d.spec.valueIndex}
+	return &DoorCheckpoint{d.id, d.hashing.name, spec, version, 0, string(d.blankPassword())}
+}
+
+func loadDoorCheckpoint(path string) (*DoorCheckpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &DoorCheckpoint{}
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("checkpoint `%s`: %v", path, err)
+	}
+	return checkpoint, nil
+}
+
+// writeFileAtomically writes to a temporary file and renames it, so a crash
+// mid-write leaves the previous contents intact. Day 14's scan cache uses it
+// too.
+func writeFileAtomically(path string, data []byte) error {
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (c DoorCheckpoint) save(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomically(path, data)
+}
+
+func (d Door) checkpointedPassword(path string, resume bool) (string, error) {
+	return d.checkpointed(1, d.resumePassword, path, resume)
+}
+
+func (d Door) checkpointedPassword2(path string, resume bool) (string, error) {
+	return d.checkpointed(2, d.resumePassword2, path, resume)
+}
+
+func (d Door) checkpointed(version int, mine func([]byte, DoorHitStream, func(int, byte)) string, path string, resume bool) (string, error) {
+	checkpoint := d.newCheckpoint(version)
+	if resume {
+		loaded, err := loadDoorCheckpoint(path)
+		if err != nil {
+			return "", err
+		}
+		if loaded.Door != d.id || loaded.Version != version {
+			return "", fmt.Errorf("checkpoint `%s` is for door `%s` password %d, not door `%s` password %d",
+				path, loaded.Door, loaded.Version, d.id, version)
+		}
+		if loaded.Algorithm != checkpoint.Algorithm || loaded.Spec != checkpoint.Spec {
+			return "", fmt.Errorf("checkpoint `%s` is for %s with %+v, not %s with %+v",
+				path, loaded.Algorithm, loaded.Spec, checkpoint.Algorithm, checkpoint.Spec)
+		}
+		if len(loaded.Password) != d.spec.passwordLen {
+			return "", fmt.Errorf("checkpoint `%s`: password `%s` is not %d characters",
+				path, loaded.Password, d.spec.passwordLen)
+		}
+		checkpoint = loaded
+	}
+
+	var saveErr error
+	save := func(index int) {
+		checkpoint.Index = index
+		if err := checkpoint.save(path); err != nil && saveErr == nil {
+			saveErr = err
+		}
+	}
+
+	password := []byte(checkpoint.Password)
+	hits := NewSequentialDoorHits(d, checkpoint.Index)
+	hits.progress = func(index int) {
+		checkpoint.Password = string(password)
+		save(index)
+	}
+	result := mine(password, hits, func(position int, char byte) {
+		checkpoint.Password = string(password)
+		save(hits.index)
+	})
+	return result, saveErr
+}
+
+// ----------------------------------------
+// cinematic decryption
+//
+//  With ANSI enabled, the password is redrawn in place every
+//  passwordAnimationInterval, with unknown positions cycling through random
+//  hex characters. Without it, a line is printed each time a position locks
+//  in, with unknown positions shown as underscores.
+
+var passwordAnimationInterval = 50 * time.Millisecond
+var hexDigits = "0123456789abcdef"
+
+const (
+	ansiClearLine  = "\r\x1b[2K"
+	ansiHideCursor = "\x1b[?25l"
+	ansiShowCursor = "\x1b[?25h"
+	ansiLocked     = "\x1b[1;32m"
+	ansiReset      = "\x1b[0m"
+)
+
+type PasswordAnimator struct {
+	output   io.Writer
+	ansi     bool
+	random   *rand.Rand
+	password []byte // spaceByte where the character isn't known yet
+	lock     sync.Mutex
+}
+
+func NewPasswordAnimator(output io.Writer, length int, ansi bool, seed int64) *PasswordAnimator {
+	password := make([]byte, length)
+	for j := range password {
+		password[j] = spaceByte
+	}
+	return &PasswordAnimator{output: output, ansi: ansi, random: rand.New(rand.NewSource(seed)), password: password}
+}
+
+func (a *PasswordAnimator) render() {
+	line := bytes.Buffer{}
+	if a.ansi {
+		line.WriteString(ansiClearLine)
+	}
+	for _, char := range a.password {
+		if char != spaceByte {
+			if a.ansi {
+				line.WriteString(ansiLocked)
+				line.WriteByte(char)
+				line.WriteString(ansiReset)
+			} else {
+				line.WriteByte(char)
 			}
+		} else if a.ansi {
+			line.WriteByte(hexDigits[a.random.Intn(len(hexDigits))])
+		} else {
+			line.WriteByte('_')
 		}
 	}
-	return string(password)
+	if !a.ansi {
+		line.WriteByte('\n')
+	}
+	a.output.Write(line.Bytes())
+}
+
+func (a *PasswordAnimator) Start() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.ansi {
+		fmt.Fprint(a.output, ansiHideCursor)
+	}
+	a.render()
+}
+
+// Frame redraws the password. It does nothing in plain-text mode, where
+// only Lock produces output.
+func (a *PasswordAnimator) Frame() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.ansi {
+		a.render()
+	}
+}
+
+func (a *PasswordAnimator) Lock(position int, char byte) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.password[position] = char
+	a.render()
+}
+
+func (a *PasswordAnimator) Finish() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.ansi {
+		a.render()
+		fmt.Fprint(a.output, "\n"+ansiShowCursor)
+	}
+}
+
+// animatedPassword2 finds the same password as password2, rendering the
+// decryption to output as it goes.
+func (d Door) animatedPassword2(output io.Writer, ansi bool) string {
+	animator := NewPasswordAnimator(output, d.spec.passwordLen, ansi, time.Now().UnixNano())
+	animator.Start()
+
+	ticker := time.NewTicker(passwordAnimationInterval)
+	done := make(chan bool)
+	exited := make(chan bool)
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case <-ticker.C:
+				animator.Frame()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	password := d.password2FromHits(NewSequentialDoorHits(d, 0), animator.Lock)
+
+	// wait for the last frame to be drawn before restoring the cursor
+	ticker.Stop()
+	close(done)
+	<-exited
+	animator.Finish()
+	return password
 }
 
 var _ = Describe("Day5", func() {
+	var mustDoor = func(door Door, err error) Door {
+		Expect(err).NotTo(HaveOccurred())
+		return door
+	}
+
 	Describe("Door", func() {
 		Describe("#password", func() {
 			It("finds the right password", func() {
-				Expect(Door{"abc"}.password()).To(Equal("18f47a30"))
+				Expect(NewDoor("abc").password()).To(Equal("18f47a30"))
 			})
 		})
 
 		Describe("#password2", func() {
 			It("finds the right password", func() {
-				Expect(Door{"abc"}.password2()).To(Equal("05ace8e3"))
+				Expect(NewDoor("abc").password2()).To(Equal("05ace8e3"))
 			})
 		})
 	})
 
+	Describe("HashAlgorithm", func() {
+		It("looks up algorithms by name", func() {
+			algorithm, err := LookupHashAlgorithm("sha256")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(algorithm.hexSum("abc")).To(Equal("ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"))
+
+			_, err = LookupHashAlgorithm("crc32")
+			Expect(err).To(MatchError("unknown hash algorithm `crc32`"))
+		})
+
+		It("agrees with the original md5sum", func() {
+			Expect(md5sum("abc3231929")).To(Equal(fmt.Sprintf("%x", md5.Sum([]byte("abc3231929")))))
+		})
+
+		It("accepts a custom function", func() {
+			reverse := HashAlgorithm{"reverse", HasherFunc(func(input []byte) []byte {
+				output := make([]byte, len(input))
+				for j, b := range input {
+					output[len(input)-1-j] = b
+				}
+				return output
+			}), defaultHashRules}
+			Expect(reverse.hexSum("ab")).To(Equal("6261"))
+		})
+	})
+
+	Describe("DoorSpec", func() {
+		It("describes the puzzle's door by default", func() {
+			Expect(NewDoor("abc").spec).To(Equal(DoorSpec{8, 5, 5, 5, 6}))
+			Expect(mustDoor(NewDoorWithHash("abc", md5Algorithm)).spec).To(Equal(defaultDoorSpec))
+		})
+
+		It("can lower the difficulty", func() {
+			Expect(mustDoor(NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 3, 3, 3, 4})).password()).
+				To(Equal(mustDoor(NewDoorWithHash("abc", md5Algorithm.withRules(HashRules{"000", 3, 5}))).password()))
+		})
+
+		It("models other door variants", func() {
+			// a 4 character password, reading characters further into the hash
+			door := mustDoor(NewDoorWithSpec("abc", md5Algorithm, DoorSpec{4, 3, 10, 10, 12}))
+			password := door.password()
+			Expect(password).To(HaveLen(4))
+
+			hits := NewSequentialDoorHits(door, 0)
+			for j := 0; j < 4; j++ {
+				Expect(password[j]).To(Equal(hits.Next().hash[10]))
+			}
+		})
+
+		It("reads positions as hex digits, so passwords can be up to 16 long", func() {
+			door := mustDoor(NewDoorWithSpec("abc", md5Algorithm, DoorSpec{16, 3, 3, 3, 4}))
+			hits := &MockDoorHits{hits: []DoorHit{{1, "000f9"}, {2, "000a7"}, {3, "000g1"}}}
+			found := []int{}
+			password := door.blankPassword()
+			for j := 0; j < 3; j++ {
+				if position, ok := door.applyPassword2Hit(password, hits.Next().hash); ok {
+					found = append(found, position)
+				}
+			}
+			Expect(found).To(Equal([]int{15, 10}))
+			Expect(password[15]).To(Equal(byte('9')))
+
+			short := mustDoor(NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 3, 3, 3, 4}))
+			_, ok := short.applyPassword2Hit(short.blankPassword(), "0008a")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Door validation", func() {
+		It("refuses specs that read past the digest or never finish", func() {
+			_, err := NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 2, 40, 3, 4})
+			Expect(err).To(MatchError("character index `40` is outside the 32 character md5 digest"))
+			_, err = NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 2, 3, -1, 4})
+			Expect(err).To(MatchError("position index `-1` is outside the 32 character md5 digest"))
+			_, err = NewDoorWithSpec("abc", sha1Algorithm, DoorSpec{8, 2, 3, 3, 40})
+			Expect(err).To(MatchError("value index `40` is outside the 40 character sha1 digest"))
+			_, err = NewDoorWithSpec("abc", md5Algorithm, DoorSpec{17, 2, 3, 3, 4})
+			Expect(err).To(MatchError("password length `17` is outside 1 to 16"))
+			_, err = NewDoorWithSpec("abc", md5Algorithm, DoorSpec{0, 2, 3, 3, 4})
+			Expect(err).To(MatchError("password length `0` is outside 1 to 16"))
+			_, err = NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 33, 3, 3, 4})
+			Expect(err).To(MatchError("difficulty `33` is outside 0 to 32, the md5 digest length"))
+			_, err = NewDoorWithHash("abc", md5Algorithm.withRules(HashRules{strings.Repeat("0", 31), 3, 5}))
+			Expect(err).To(MatchError("value index `32` is outside the 32 character md5 digest"))
+		})
+	})
+
+	Describe("Door with other hash algorithms", func() {
+		It("matches the algorithm's own prefix, whatever its characters", func() {
+			door := mustDoor(NewDoorWithHash("abc", md5Algorithm.withRules(HashRules{"abc", 3, 5})))
+			Expect(door.prefix).To(Equal("abc"))
+			password := door.password()
+			hits := NewSequentialDoorHits(door, 0)
+			for j := 0; j < 8; j++ {
+				hash := hits.Next().hash
+				Expect(hash).To(HavePrefix("abc"))
+				Expect(password[j]).To(Equal(hash[3]))
+			}
+
+			_, err := door.scanner("")
+			Expect(err).To(MatchError("door prefix `abc` isn't leading zeros, which is all a HashScanner finds"))
+		})
+
+		It("mines SHA-256 hashes with its own prefix", func() {
+			door := mustDoor(NewDoorWithHash("abc", sha256Algorithm.withRules(HashRules{"000", 3, 5})))
+			Expect(door.password()).To(Equal("24b48d84"))
+		})
+
+		It("mines SHA-1 hashes with its own prefix", func() {
+			door := mustDoor(NewDoorWithHash("abc", sha1Algorithm.withRules(HashRules{"0000", 3, 5})))
+			Expect(door.password()).To(Equal("482ef9b4"))
+			Expect(door.parallelPassword(2)).To(Equal("482ef9b4"))
+		})
+	})
+
+	Describe("scanned mining", func() {
+		door := mustDoor(NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 3, 3, 3, 4}))
+
+		It("finds the same passwords as the sequential code", func() {
+			password, err := door.scannedPassword("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(password).To(Equal(door.password()))
+
+			password, err = door.scannedPassword2("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(password).To(Equal(door.password2()))
+		})
+
+		It("resumes from an index past the scanned range", func() {
+			scanner, err := door.scanner("")
+			Expect(err).NotTo(HaveOccurred())
+			scanned := NewScannedDoorHits(scanner, 5000)
+			sequential := NewSequentialDoorHits(door, 5000)
+			for j := 0; j < 5; j++ {
+				Expect(scanned.Next()).To(Equal(sequential.Next()))
+			}
+		})
+
+		It("reuses a cached scan", func() {
+			dir, _ := ioutil.TempDir("", "day5")
+			defer os.RemoveAll(dir)
+
+			password, err := door.scannedPassword2(dir)
+			Expect(err).NotTo(HaveOccurred())
+
+			scanner, err := door.scanner(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(scanner.scanned).To(BeNumerically(">", 0))
+			Expect(door.password2FromHits(NewScannedDoorHits(scanner, 0), nil)).To(Equal(password))
+		})
+
+		It("reports a cache it couldn't save", func() {
+			dir, _ := ioutil.TempDir("", "day5")
+			scanner, err := door.scanner(dir)
+			Expect(err).NotTo(HaveOccurred())
+			os.RemoveAll(dir)
+
+			hits := NewScannedDoorHits(scanner, 0)
+			hits.Next()
+			hits.Stop()
+			Expect(hits.Err()).To(HaveOccurred())
+		})
+	})
+
+	Describe("ParallelDoorMiner", func() {
+		It("hands out hits in index order", func() {
+			door := mustDoor(NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 3, 3, 3, 4}))
+			sequential := NewSequentialDoorHits(door, 0)
+			parallel := NewParallelDoorMinerWithBatchSize(door, 0, 3, 100)
+			defer parallel.Stop()
+			for j := 0; j < 20; j++ {
+				Expect(parallel.Next()).To(Equal(sequential.Next()))
+			}
+		})
+
+		It("finds the same passwords as the sequential code", func() {
+			door := mustDoor(NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 4, 4, 4, 5}))
+			Expect(door.parallelPassword(0)).To(Equal(door.password()))
+			Expect(door.parallelPassword2(4)).To(Equal(door.password2()))
+		})
+
+		It("waits for its workers to stop", func() {
+			// once holding, every worker blocks in its first hash until released
+			var running, holding int64
+			entered := make(chan bool, 4)
+			release := make(chan bool)
+			blocking := HashAlgorithm{"blocking", HasherFunc(func(input []byte) []byte {
+				if atomic.LoadInt64(&holding) == 1 {
+					atomic.AddInt64(&running, 1)
+					defer atomic.AddInt64(&running, -1)
+					select {
+					case entered <- true:
+					default:
+					}
+					<-release
+				}
+				digest := md5.Sum(input)
+				return digest[:]
+			}), defaultHashRules}
+			door := mustDoor(NewDoorWithSpec("abc", blocking, DoorSpec{8, 3, 3, 3, 4}))
+			atomic.StoreInt64(&holding, 1)
+			parallel := NewParallelDoorMinerWithBatchSize(door, 0, 4, 50)
+			for j := 0; j < 4; j++ {
+				<-entered
+			}
+
+			stopped := make(chan bool)
+			go func() {
+				parallel.Stop()
+				close(stopped)
+			}()
+			<-parallel.stop
+			close(release)
+			<-stopped
+			Expect(atomic.LoadInt64(&running)).To(Equal(int64(0)))
+		})
+	})
+
+	Describe("password2FromHits", func() {
+		It("reports each position as it is found", func() {
+			hits := &MockDoorHits{hits: []DoorHit{
+				{1, "000003a"}, {2, "000009z"}, {3, "000003b"}, {4, "000000c"},
+				{5, "0000011"}, {6, "0000022"}, {7, "0000044"}, {8, "0000055"},
+				{9, "0000066"}, {10, "0000077"},
+			}}
+			found := []string{}
+			password := NewDoor("abc").password2FromHits(hits, func(position int, char byte) {
+				found = append(found, fmt.Sprintf("%d=%c", position, char))
+			})
+			Expect(password).To(Equal("c12a4567"))
+			Expect(found).To(Equal([]string{"3=a", "0=c", "1=1", "2=2", "4=4", "5=5", "6=6", "7=7"}))
+			Expect(hits.stopped).To(BeTrue())
+		})
+	})
+
+	Describe("checkpoints", func() {
+		// a much easier door, so these run quickly
+		door := mustDoor(NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 3, 3, 3, 4}))
+		var path string
+
+		BeforeEach(func() {
+			dir, _ := ioutil.TempDir("", "day5")
+			path = dir + "/checkpoint.json"
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(filepath.Dir(path))
+		})
+
+		It("writes checkpoints as it mines", func() {
+			interval := doorProgressInterval
+			doorProgressInterval = 1000
+			defer func() { doorProgressInterval = interval }()
+
+			password, err := door.checkpointedPassword2(path, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(password).To(Equal(door.password2()))
+
+			checkpoint, err := loadDoorCheckpoint(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(checkpoint.Door).To(Equal("abc"))
+			Expect(checkpoint.Version).To(Equal(2))
+			Expect(checkpoint.Password).To(Equal(password))
+		})
+
+		It("resumes an interrupted run with identical output", func() {
+			// mine a few hits by hand, as though interrupted
+			hits := NewSequentialDoorHits(door, 0)
+			partial := door.blankPassword()
+			for j := 0; j < 2; j++ {
+				door.applyPasswordHit(partial, hits.Next().hash)
+			}
+			checkpoint := door.newCheckpoint(1)
+			checkpoint.Index, checkpoint.Password = hits.index, string(partial)
+			Expect(checkpoint.save(path)).To(Succeed())
+
+			password, err := door.checkpointedPassword(path, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(password).To(Equal(door.password()))
+		})
+
+		It("resumes password2 with identical output", func() {
+			hits := NewSequentialDoorHits(door, 0)
+			partial := door.blankPassword()
+			for j := 0; j < 10; j++ {
+				door.applyPassword2Hit(partial, hits.Next().hash)
+			}
+			checkpoint := door.newCheckpoint(2)
+			checkpoint.Index, checkpoint.Password = hits.index, string(partial)
+			Expect(checkpoint.save(path)).To(Succeed())
+
+			password, err := door.checkpointedPassword2(path, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(password).To(Equal(door.password2()))
+		})
+
+		It("refuses checkpoints for another door", func() {
+			Expect(mustDoor(NewDoorWithSpec("xyz", md5Algorithm, DoorSpec{8, 3, 3, 3, 4})).newCheckpoint(2).save(path)).To(Succeed())
+			_, err := door.checkpointedPassword2(path, true)
+			Expect(err).To(MatchError("checkpoint `" + path + "` is for door `xyz` password 2, not door `abc` password 2"))
+		})
+
+		It("refuses checkpoints for another algorithm or spec", func() {
+			Expect(mustDoor(NewDoorWithSpec("abc", sha1Algorithm, DoorSpec{8, 3, 3, 3, 4})).newCheckpoint(2).save(path)).To(Succeed())
+			_, err := door.checkpointedPassword2(path, true)
+			Expect(err).To(MatchError(ContainSubstring("is for sha1 with {Prefix:000 PasswordLen:8 Difficulty:3 CharIndex:3 PositionIndex:3 ValueIndex:4}, not md5 with")))
+
+			Expect(mustDoor(NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 4, 4, 4, 5})).newCheckpoint(2).save(path)).To(Succeed())
+			_, err = door.checkpointedPassword2(path, true)
+			Expect(err).To(MatchError("checkpoint `" + path + "` is for md5 with {Prefix:0000 PasswordLen:8 Difficulty:4 CharIndex:4 PositionIndex:4 ValueIndex:5}, " +
+				"not md5 with {Prefix:000 PasswordLen:8 Difficulty:3 CharIndex:3 PositionIndex:3 ValueIndex:4}"))
+		})
+
+		It("complains about missing checkpoints", func() {
+			_, err := door.checkpointedPassword2(path, true)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("PasswordAnimator", func() {
+		It("prints a line per locked position in plain text", func() {
+			output := bytes.Buffer{}
+			animator := NewPasswordAnimator(&output, 8, false, 1)
+			animator.Start()
+			animator.Frame()
+			animator.Lock(3, 'a')
+			animator.Lock(0, 'c')
+			animator.Finish()
+			Expect(output.String()).To(Equal("________\n___a____\nc__a____\n"))
+		})
+
+		It("cycles unknown positions through hex characters with ANSI", func() {
+			output := bytes.Buffer{}
+			animator := NewPasswordAnimator(&output, 8, true, 1)
+			animator.Lock(3, 'a')
+			frame := output.String()
+			Expect(frame).To(HavePrefix(ansiClearLine))
+			Expect(frame).To(ContainSubstring(ansiLocked + "a" + ansiReset))
+
+			plain := regexp.MustCompile(`\x1b\[[0-9;]*m`).ReplaceAllString(frame[len(ansiClearLine):], "")
+			Expect(plain).To(MatchRegexp(`^[0-9a-f]{3}a[0-9a-f]{4}$`))
+
+			animator.Finish()
+			Expect(output.String()).To(HaveSuffix("\n" + ansiShowCursor))
+		})
+	})
+
+	Describe("#animatedPassword2", func() {
+		door := mustDoor(NewDoorWithSpec("abc", md5Algorithm, DoorSpec{8, 3, 3, 3, 4}))
+
+		It("prints each position as it locks in, in plain text", func() {
+			output := bytes.Buffer{}
+			Expect(door.animatedPassword2(&output, false)).To(Equal(door.password2()))
+			lines := strings.Split(output.String(), "\n")
+			Expect(lines).To(HaveLen(10))
+			Expect(lines[0]).To(Equal("________"))
+			Expect(lines[8]).To(Equal(door.password2()))
+			Expect(lines[9]).To(Equal(""))
+		})
+
+		It("draws nothing after restoring the cursor", func() {
+			interval := passwordAnimationInterval
+			passwordAnimationInterval = time.Millisecond
+			defer func() { passwordAnimationInterval = interval }()
+
+			for j := 0; j < 5; j++ {
+				output := bytes.Buffer{}
+				password := door.animatedPassword2(&output, true)
+				Expect(output.String()).To(HavePrefix(ansiHideCursor))
+				last := output.String()[strings.LastIndex(output.String(), ansiClearLine)+len(ansiClearLine):]
+				plain := regexp.MustCompile(`\x1b\[[0-9;]*m`).ReplaceAllString(last, "")
+				Expect(plain).To(Equal(password + "\n" + ansiShowCursor))
+				Expect(strings.Count(output.String(), ansiShowCursor)).To(Equal(1))
+			}
+		})
+	})
+
 	Describe("star 1", func() {
 		It("finds the answer", func() {
-			fmt.Println("star 1: ", Door{"ojvtpuvg"}.password())
+			fmt.Println("star 1: ", NewDoor("ojvtpuvg").password())
 		})
 	})
 
 	Describe("star 2", func() {
 		It("finds the answer", func() {
-			fmt.Println("star 2: ", Door{"ojvtpuvg"}.password2())
+			door := NewDoor("ojvtpuvg")
+			if *doorCheckpointPath != "" {
+				password, err := door.checkpointedPassword2(*doorCheckpointPath, *doorResume)
+				Expect(err).NotTo(HaveOccurred())
+				fmt.Println("star 2: ", password)
+			} else {
+				fmt.Println("star 2: ", door.animatedPassword2(os.Stdout, false))
+			}
 		})
 	})
 })
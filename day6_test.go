@@ -1,11 +1,15 @@
 package adventofcode2016_test
 
 import (
+	"bufio"
+	"container/heap"
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"io"
 	"io/ioutil"
-	"sort"
+	"math"
+	"os"
 	"strings"
 )
 
@@ -13,59 +17,364 @@ type RepetitionDecoder struct {
 	messages []string
 }
 
-func (d RepetitionDecoder) calculateFrequencyDistribution() []SortableNameComponents {
-	messageLen := len(d.messages[0])
-	counts := make([]map[byte]uint, messageLen)
+func (d RepetitionDecoder) streaming() *StreamingRepetitionDecoder {
+	streaming := NewStreamingRepetitionDecoder()
+	for _, message := range d.messages {
+		streaming.AddMessage(message)
+	}
+	return streaming
+}
+
+func (d RepetitionDecoder) calculateFrequencyDistribution() []*FrequencyCounter {
+	return d.streaming().columns
+}
+
+func (d RepetitionDecoder) decode() string {
+	return d.streaming().decode()
+}
+
+func (d RepetitionDecoder) decode2() string {
+	return d.streaming().decode2()
+}
 
-	for j := 0; j < messageLen; j++ {
-		counts[j] = make(map[byte]uint)
+// ----------------------------------------
+// streaming decoder
+//
+//  Messages are consumed one at a time, and may be of any length. Blank
+//  lines are skipped. Each column keeps its own counts, so a column only
+//  reflects the messages long enough to reach it.
+
+type StreamingRepetitionDecoder struct {
+	columns  []*FrequencyCounter // FrequencyCounter is from day 4
+	messages int
+}
+
+func NewStreamingRepetitionDecoder() *StreamingRepetitionDecoder {
+	return &StreamingRepetitionDecoder{make([]*FrequencyCounter, 0), 0}
+}
+
+func (d *StreamingRepetitionDecoder) AddMessage(message string) {
+	message = strings.TrimRight(message, "\r\n")
+	if strings.TrimSpace(message) == "" {
+		return
 	}
+	for len(d.columns) < len(message) {
+		d.columns = append(d.columns, NewFrequencyCounter())
+	}
+	for j, char := range []byte(message) {
+		d.columns[j].Add(rune(char))
+	}
+	d.messages++
+}
 
-	for _, message := range d.messages {
-		for j, char := range []byte(message) {
-			count := counts[j]
-			if n, ok := count[char]; ok {
-				count[char] = n + 1
-			} else {
-				count[char] = 1
+// Consume reads messages, one per line, until the reader is exhausted.
+func (d *StreamingRepetitionDecoder) Consume(input io.Reader) error {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		d.AddMessage(scanner.Text())
+	}
+	return scanner.Err()
+}
+
+func (d *StreamingRepetitionDecoder) decodeWith(pick func(*FrequencyCounter) rune) string {
+	decodedMessage := make([]rune, len(d.columns))
+	for j, counter := range d.columns {
+		decodedMessage[j] = pick(counter)
+	}
+	return string(decodedMessage)
+}
+
+// decode uses the most common character in each column.
+func (d *StreamingRepetitionDecoder) decode() string {
+	return d.decodeWith(func(counter *FrequencyCounter) rune {
+		return counter.TopN(1)[0].element
+	})
+}
+
+// decode2 uses the least common character in each column.
+func (d *StreamingRepetitionDecoder) decode2() string {
+	return d.decodeWith(func(counter *FrequencyCounter) rune {
+		return counter.BottomN(1)[0].element
+	})
+}
+
+// ----------------------------------------
+// confidence reports
+
+type PositionReport struct {
+	candidates []FrequencyEntry // top-k, most common first
+	total      uint             // messages that reached this position
+	margin     uint             // occurrences of the winner over the runner-up
+	ambiguous  bool             // margin is within the report's threshold
+}
+
+type DecodingReport struct {
+	positions []PositionReport
+}
+
+// report gives the top k candidates for each position, treating k < 1 as 1.
+// A position is flagged ambiguous when the winner leads the runner-up by no
+// more than ambiguityMargin, so 0 flags only outright ties.
+func (d *StreamingRepetitionDecoder) report(k int, ambiguityMargin uint) DecodingReport {
+	if k < 1 {
+		k = 1
+	}
+	positions := make([]PositionReport, len(d.columns))
+	for j, counter := range d.columns {
+		sorted := counter.Sorted()
+		candidates := sorted
+		if k < len(sorted) {
+			candidates = sorted[:k]
+		}
+		margin := sorted[0].occurrences
+		if len(sorted) > 1 {
+			margin -= sorted[1].occurrences
+		}
+		positions[j] = PositionReport{candidates, counter.Total(), margin, margin <= ambiguityMargin && len(sorted) > 1}
+	}
+	return DecodingReport{positions}
+}
+
+func (r DecodingReport) ambiguousPositions() []int {
+	ambiguous := make([]int, 0)
+	for j, position := range r.positions {
+		if position.ambiguous {
+			ambiguous = append(ambiguous, j)
+		}
+	}
+	return ambiguous
+}
+
+type Decoding struct {
+	message       string
+	logLikelihood float64 // sum over positions of log(occurrences/total)
+}
+
+// decodingSearchState picks one candidate per position, by index into
+// PositionReport.candidates.
+type decodingSearchState struct {
+	choices       []int
+	logLikelihood float64
+}
+
+type decodingSearchQueue []decodingSearchState
+
+func (q decodingSearchQueue) Len() int      { return len(q) }
+func (q decodingSearchQueue) Swap(j, k int) { q[j], q[k] = q[k], q[j] }
+func (q decodingSearchQueue) Less(j, k int) bool {
+	if q[j].logLikelihood == q[k].logLikelihood {
+		// equally likely; prefer better candidates in earlier positions
+		for n := range q[j].choices {
+			if q[j].choices[n] != q[k].choices[n] {
+				return q[j].choices[n] < q[k].choices[n]
 			}
 		}
+		return false
+	}
+	return q[j].logLikelihood > q[k].logLikelihood
+}
+func (q *decodingSearchQueue) Push(x interface{}) { *q = append(*q, x.(decodingSearchState)) }
+func (q *decodingSearchQueue) Pop() interface{} {
+	old := *q
+	state := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return state
+}
+
+func (r DecodingReport) logLikelihood(choices []int) float64 {
+	sum := 0.0
+	for j, choice := range choices {
+		position := r.positions[j]
+		sum += math.Log(float64(position.candidates[choice].occurrences) / float64(position.total))
+	}
+	return sum
+}
+
+func (r DecodingReport) message(choices []int) string {
+	message := make([]rune, len(choices))
+	for j, choice := range choices {
+		message[j] = r.positions[j].candidates[choice].element
+	}
+	return string(message)
+}
+
+// alternatives enumerates up to n decodings built from the report's
+// candidates, most likely first, treating positions as independent. A
+// negative n gives none.
+func (r DecodingReport) alternatives(n int) []Decoding {
+	if n < 0 {
+		n = 0
 	}
+	decodings := make([]Decoding, 0, n)
+	start := make([]int, len(r.positions))
+	queue := &decodingSearchQueue{{start, r.logLikelihood(start)}}
+	seen := map[string]bool{fmt.Sprint(start): true}
+
+	for queue.Len() > 0 && len(decodings) < n {
+		state := heap.Pop(queue).(decodingSearchState)
+		decodings = append(decodings, Decoding{r.message(state.choices), state.logLikelihood})
 
-	distribution := make([]SortableNameComponents, messageLen)
-	for j := 0; j < messageLen; j++ {
-		// convert to an array of SortableNameComponent (from day 4)
-		count := counts[j]
-		components := make(SortableNameComponents, 0, len(count)/2)
-		for element, occurrences := range count {
-			components = append(components, SortableNameComponent{element, occurrences})
+		// successors swap one position to its next candidate
+		for j := range state.choices {
+			if state.choices[j]+1 >= len(r.positions[j].candidates) {
+				continue
+			}
+			choices := append([]int{}, state.choices...)
+			choices[j]++
+			if key := fmt.Sprint(choices); !seen[key] {
+				seen[key] = true
+				heap.Push(queue, decodingSearchState{choices, r.logLikelihood(choices)})
+			}
 		}
-		sort.Sort(components)
-		distribution[j] = components
 	}
-	return distribution
+	return decodings
 }
 
-func (d RepetitionDecoder) decode() string {
-	distribution := d.calculateFrequencyDistribution()
-	decodedMessage := ""
+// ----------------------------------------
+// noise-model decoding
+//
+//  Each message is the original, with each character independently
+//  corrupted according to a NoiseModel. For each position, the posterior of
+//  an original character c given the observed counts is proportional to
+//  the product over observed characters o of P(o|c)^count(o), with a
+//  uniform prior over the candidates.
+
+type NoiseModel interface {
+	validate() error
+	// candidates are the possible original characters, given what was observed
+	candidates(observed *FrequencyCounter) []rune
+	// logLikelihood is log P(observed|original), where alphabetSize is the
+	// number of candidates at this position
+	logLikelihood(observed, original rune, alphabetSize int) float64
+}
 
-	for _, components := range distribution {
-		decodedMessage += string(components[0].element)
+// noiseFloor stands in for probabilities too small to take the log of, so
+// that a "never" in a model makes a candidate very unlikely rather than
+// turning every posterior into NaN.
+const noiseFloor = 1e-12
+
+func flooredLog(p float64) float64 {
+	if p < noiseFloor {
+		p = noiseFloor
 	}
+	return math.Log(p)
+}
 
-	return string(decodedMessage)
+// UniformNoise replaces each character with probability `rate`, choosing
+// uniformly among the other letters. When letters is empty, the characters
+// seen at each position are the alphabet.
+type UniformNoise struct {
+	rate    float64
+	letters string
 }
 
-func (d RepetitionDecoder) decode2() string {
-	distribution := d.calculateFrequencyDistribution()
-	decodedMessage := ""
+func (n UniformNoise) validate() error {
+	if !(n.rate >= 0 && n.rate <= 1) {
+		return fmt.Errorf("noise rate `%v` is outside 0 to 1", n.rate)
+	}
+	return nil
+}
 
-	for _, components := range distribution {
-		decodedMessage += string(components[len(components)-1].element)
+func (n UniformNoise) candidates(observed *FrequencyCounter) []rune {
+	if n.letters != "" {
+		return []rune(n.letters)
+	}
+	candidates := make([]rune, 0, observed.Len())
+	for _, entry := range observed.Sorted() {
+		candidates = append(candidates, entry.element)
 	}
+	return candidates
+}
 
-	return string(decodedMessage)
+func (n UniformNoise) logLikelihood(observed, original rune, alphabetSize int) float64 {
+	if observed == original {
+		return flooredLog(1 - n.rate)
+	}
+	if alphabetSize < 2 {
+		alphabetSize = 2
+	}
+	return flooredLog(n.rate / float64(alphabetSize-1))
+}
+
+// ConfusionNoise gives P(observed|original) as matrix[original][observed].
+// Missing entries are treated as nearly impossible.
+type ConfusionNoise struct {
+	matrix map[rune]map[rune]float64
+}
+
+func (n ConfusionNoise) validate() error {
+	if len(n.matrix) == 0 {
+		return fmt.Errorf("confusion matrix is empty")
+	}
+	for original, row := range n.matrix {
+		for observed, p := range row {
+			if !(p >= 0 && p <= 1) {
+				return fmt.Errorf("P(`%c`|`%c`) = `%v` is outside 0 to 1", observed, original, p)
+			}
+		}
+	}
+	return nil
+}
+
+func (n ConfusionNoise) candidates(observed *FrequencyCounter) []rune {
+	candidates := make([]rune, 0, len(n.matrix))
+	for original := range n.matrix {
+		candidates = append(candidates, original)
+	}
+	return candidates
+}
+
+func (n ConfusionNoise) logLikelihood(observed, original rune, alphabetSize int) float64 {
+	return flooredLog(n.matrix[original][observed])
+}
+
+type NoisyDecoding struct {
+	message    string
+	posteriors []float64 // posterior probability of each position's character
+}
+
+func noisyDecode(distribution []*FrequencyCounter, model NoiseModel) (NoisyDecoding, error) {
+	if err := model.validate(); err != nil {
+		return NoisyDecoding{}, err
+	}
+	message := make([]rune, len(distribution))
+	posteriors := make([]float64, len(distribution))
+
+	for j, counter := range distribution {
+		candidates := model.candidates(counter)
+		if len(candidates) == 0 {
+			return NoisyDecoding{}, fmt.Errorf("position %d: the noise model has no candidates", j)
+		}
+		observed := counter.Sorted()
+		logPosteriors := make([]float64, len(candidates))
+		best := 0
+		for k, original := range candidates {
+			for _, entry := range observed {
+				logPosteriors[k] += float64(entry.occurrences) * model.logLikelihood(entry.element, original, len(candidates))
+			}
+			if logPosteriors[k] > logPosteriors[best] ||
+				(logPosteriors[k] == logPosteriors[best] && original < candidates[best]) {
+				best = k
+			}
+		}
+
+		message[j] = candidates[best]
+		total := 0.0
+		for _, logPosterior := range logPosteriors {
+			total += math.Exp(logPosterior - logPosteriors[best])
+		}
+		posteriors[j] = 1 / total
+	}
+
+	return NoisyDecoding{string(message), posteriors}, nil
+}
+
+func (d RepetitionDecoder) decodeNoisy(model NoiseModel) (NoisyDecoding, error) {
+	return noisyDecode(d.calculateFrequencyDistribution(), model)
+}
+
+func (d *StreamingRepetitionDecoder) decodeNoisy(model NoiseModel) (NoisyDecoding, error) {
+	return noisyDecode(d.columns, model)
 }
 
 var _ = Describe("Day6", func() {
@@ -90,6 +399,195 @@ var _ = Describe("Day6", func() {
 		})
 	})
 
+	Describe("StreamingRepetitionDecoder", func() {
+		It("decodes from a reader", func() {
+			file, err := os.Open("day6_test.txt")
+			Expect(err).NotTo(HaveOccurred())
+			defer file.Close()
+
+			decoder := NewStreamingRepetitionDecoder()
+			Expect(decoder.Consume(file)).To(Succeed())
+			Expect(decoder.messages).To(Equal(16))
+			Expect(decoder.decode()).To(Equal("easter"))
+			Expect(decoder.decode2()).To(Equal("advent"))
+		})
+
+		It("tolerates blank lines and variable-length messages", func() {
+			decoder := NewStreamingRepetitionDecoder()
+			Expect(decoder.Consume(strings.NewReader("ab\n\nabc\r\n  \nxbcd\n"))).To(Succeed())
+			Expect(decoder.messages).To(Equal(3))
+			Expect(decoder.decode()).To(Equal("abcd"))
+			Expect(decoder.decode2()).To(Equal("xbcd"))
+		})
+
+		It("decodes at any point", func() {
+			decoder := NewStreamingRepetitionDecoder()
+			Expect(decoder.decode()).To(Equal(""))
+
+			decoder.AddMessage("xyz")
+			Expect(decoder.decode()).To(Equal("xyz"))
+
+			decoder.AddMessage("abz")
+			decoder.AddMessage("abc")
+			Expect(decoder.decode()).To(Equal("abz"))
+			Expect(decoder.decode2()).To(Equal("xyc"))
+		})
+	})
+
+	Describe("DecodingReport", func() {
+		decoder := NewStreamingRepetitionDecoder()
+		for _, message := range []string{"abc", "abd", "abd", "xyd", "xbc", "ayc"} {
+			decoder.AddMessage(message)
+		}
+		report := decoder.report(2, 0)
+
+		It("gives the top-k characters for each position", func() {
+			Expect(report.positions[0]).To(Equal(PositionReport{
+				[]FrequencyEntry{{'a', 4}, {'x', 2}}, 6, 2, false,
+			}))
+			Expect(report.positions[1].candidates).To(Equal([]FrequencyEntry{{'b', 4}, {'y', 2}}))
+		})
+
+		It("flags ties as ambiguous", func() {
+			Expect(report.positions[2].margin).To(Equal(uint(0)))
+			Expect(report.positions[2].ambiguous).To(BeTrue())
+			Expect(report.ambiguousPositions()).To(Equal([]int{2}))
+		})
+
+		It("gives at least one candidate", func() {
+			for _, k := range []int{0, -1} {
+				clamped := decoder.report(k, 0)
+				Expect(clamped.positions[0].candidates).To(Equal([]FrequencyEntry{{'a', 4}}))
+				Expect(clamped.positions[0].margin).To(Equal(uint(2)))
+				Expect(clamped.positions[2].ambiguous).To(BeTrue())
+			}
+		})
+
+		It("flags near-ties with a wider threshold", func() {
+			Expect(decoder.report(2, 2).ambiguousPositions()).To(Equal([]int{0, 1, 2}))
+		})
+
+		It("doesn't flag a position with only one character", func() {
+			single := NewStreamingRepetitionDecoder()
+			single.AddMessage("a")
+			Expect(single.report(3, 0).positions[0].ambiguous).To(BeFalse())
+		})
+
+		It("enumerates alternatives by joint likelihood", func() {
+			alternatives := report.alternatives(4)
+			Expect(alternatives).To(HaveLen(4))
+			Expect(alternatives[0].message).To(Equal("abc"))
+			Expect(alternatives[1].message).To(Equal("abd"))
+			Expect(alternatives[0].logLikelihood).To(BeNumerically("~", alternatives[1].logLikelihood, 1e-9))
+			Expect(alternatives[0].logLikelihood).To(BeNumerically("~", 2*math.Log(4.0/6.0)+math.Log(0.5), 1e-9))
+			Expect(alternatives[2].message).To(Equal("ayc"))
+			Expect(alternatives[3].message).To(Equal("ayd"))
+
+			Expect(report.alternatives(100)).To(HaveLen(8))
+		})
+
+		It("gives no alternatives for a negative count", func() {
+			Expect(report.alternatives(-1)).To(BeEmpty())
+			Expect(report.alternatives(0)).To(BeEmpty())
+		})
+
+		It("agrees with decode on the example", func() {
+			file, _ := os.Open("day6_test.txt")
+			defer file.Close()
+			decoder := NewStreamingRepetitionDecoder()
+			decoder.Consume(file)
+			Expect(decoder.report(3, 0).alternatives(1)[0].message).To(Equal(decoder.decode()))
+		})
+	})
+
+	Describe("noise-model decoding", func() {
+		messages := parseFile("day6_test.txt")
+
+		It("matches decode when corruption is rare", func() {
+			decoding, err := RepetitionDecoder{messages}.decodeNoisy(UniformNoise{0.1, ""})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoding.message).To(Equal("easter"))
+			for _, posterior := range decoding.posteriors {
+				Expect(posterior).To(BeNumerically(">", 0.5))
+				Expect(posterior).To(BeNumerically("<=", 1))
+			}
+		})
+
+		It("matches decode2 when corruption is almost certain", func() {
+			decoding, err := RepetitionDecoder{messages}.decodeNoisy(UniformNoise{0.99, ""})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoding.message).To(Equal("advent"))
+		})
+
+		It("is unsure when the rate makes every character equally likely", func() {
+			decoder := NewStreamingRepetitionDecoder()
+			decoder.AddMessage("a")
+			decoder.AddMessage("a")
+			decoder.AddMessage("b")
+			decoding, err := decoder.decodeNoisy(UniformNoise{0.5, "ab"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoding.posteriors[0]).To(BeNumerically("~", 0.5, 1e-9))
+
+			decoding, err = decoder.decodeNoisy(UniformNoise{0.2, "ab"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoding.message).To(Equal("a"))
+			// P(a) ∝ 0.8² × 0.2, P(b) ∝ 0.2² × 0.8
+			Expect(decoding.posteriors[0]).To(BeNumerically("~", 0.8, 1e-9))
+		})
+
+		It("uses a confusion matrix", func() {
+			// e is usually misread as c; the c's we see are really e's
+			model := ConfusionNoise{map[rune]map[rune]float64{
+				'e': {'e': 0.3, 'c': 0.7},
+				'c': {'c': 0.95, 'e': 0.05},
+			}}
+			decoder := NewStreamingRepetitionDecoder()
+			for _, message := range []string{"c", "c", "e", "c", "e"} {
+				decoder.AddMessage(message)
+			}
+			Expect(decoder.decode()).To(Equal("c"))
+			decoding, err := decoder.decodeNoisy(model)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(decoding.message).To(Equal("e"))
+			// P(e) ∝ 0.7³ × 0.3², P(c) ∝ 0.95³ × 0.05²
+			pe, pc := 0.343*0.09, 0.857375*0.0025
+			Expect(decoding.posteriors[0]).To(BeNumerically("~", pe/(pe+pc), 1e-9))
+		})
+
+		It("stays finite when the model calls something impossible", func() {
+			decoder := NewStreamingRepetitionDecoder()
+			for _, message := range []string{"a", "b", "a"} {
+				decoder.AddMessage(message)
+			}
+			for _, model := range []NoiseModel{UniformNoise{0, ""}, UniformNoise{1, "ab"}, UniformNoise{0, "xy"}} {
+				decoding, err := decoder.decodeNoisy(model)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(math.IsNaN(decoding.posteriors[0])).To(BeFalse(), fmt.Sprint(model))
+				Expect(decoding.posteriors[0]).To(BeNumerically(">", 0))
+				Expect(decoding.posteriors[0]).To(BeNumerically("<=", 1))
+			}
+			decoding, _ := decoder.decodeNoisy(UniformNoise{0, ""})
+			Expect(decoding.message).To(Equal("a"))
+			decoding, _ = decoder.decodeNoisy(UniformNoise{0, "xy"})
+			Expect(decoding.posteriors[0]).To(BeNumerically("~", 0.5, 1e-9))
+		})
+
+		It("refuses invalid models", func() {
+			decoder := NewStreamingRepetitionDecoder()
+			decoder.AddMessage("a")
+			_, err := decoder.decodeNoisy(UniformNoise{1.5, ""})
+			Expect(err).To(MatchError("noise rate `1.5` is outside 0 to 1"))
+			_, err = decoder.decodeNoisy(UniformNoise{-0.1, ""})
+			Expect(err).To(MatchError("noise rate `-0.1` is outside 0 to 1"))
+			_, err = decoder.decodeNoisy(UniformNoise{math.NaN(), ""})
+			Expect(err).To(MatchError("noise rate `NaN` is outside 0 to 1"))
+			_, err = decoder.decodeNoisy(ConfusionNoise{map[rune]map[rune]float64{}})
+			Expect(err).To(MatchError("confusion matrix is empty"))
+			_, err = decoder.decodeNoisy(ConfusionNoise{map[rune]map[rune]float64{'a': {'b': 2}}})
+			Expect(err).To(MatchError("P(`b`|`a`) = `2` is outside 0 to 1"))
+		})
+	})
+
 	Describe("RepetitionDecoder", func() {
 		messages := parseFile("day6_data.txt")
 
@@ -1,23 +1,121 @@
 package adventofcode2016_test
 
 import (
+	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"io"
 	"io/ioutil"
-	"regexp"
+	"math/rand"
+	"os"
 	"strings"
 )
 
-func stringHasAbbaNature(word string) bool {
-	for j := 0; j < len(word)-3; j++ {
-		if word[j] == word[j+3] &&
-			word[j+1] == word[j+2] &&
-			word[j] != word[j+1] {
-			return true
+// ----------------------------------------
+// palindromic windows
+
+type PalindromeMatch struct {
+	offset int
+	window string
+}
+
+// PalindromeDetector finds palindromic windows of a fixed length. With
+// innerMustDiffer, every character strictly inside the window must differ
+// from the outer one, so "abba" and "aba" match but "aaaa" and "aaa" don't.
+type PalindromeDetector struct {
+	length          int
+	innerMustDiffer bool
+}
+
+var abbaDetector = PalindromeDetector{4, true}
+var abaDetector = PalindromeDetector{3, true}
+
+// palindromeRadii is Manacher's algorithm. odd[i] counts the odd-length
+// palindromes centered on i; even[i] counts the even-length ones centered
+// between i-1 and i.
+func palindromeRadii(word string) (odd []int, even []int) {
+	n := len(word)
+	odd = make([]int, n)
+	even = make([]int, n)
+	for i, l, r := 0, 0, -1; i < n; i++ {
+		k := 1
+		if i <= r {
+			k = odd[l+r-i]
+			if r-i+1 < k {
+				k = r - i + 1
+			}
+		}
+		for i-k >= 0 && i+k < n && word[i-k] == word[i+k] {
+			k++
+		}
+		odd[i] = k
+		if i+k-1 > r {
+			l, r = i-k+1, i+k-1
 		}
 	}
-	return false
+	for i, l, r := 0, 0, -1; i < n; i++ {
+		k := 0
+		if i <= r {
+			k = even[l+r-i+1]
+			if r-i+1 < k {
+				k = r - i + 1
+			}
+		}
+		for i-k-1 >= 0 && i+k < n && word[i-k-1] == word[i+k] {
+			k++
+		}
+		even[i] = k
+		if i+k-1 > r {
+			l, r = i-k, i+k-1
+		}
+	}
+	return
+}
+
+// Find returns every matching window, in order, in time linear in the
+// length of the word.
+func (pd PalindromeDetector) Find(word string) []PalindromeMatch {
+	matches := make([]PalindromeMatch, 0)
+	k := pd.length
+	if k < 2 || len(word) < k {
+		return matches
+	}
+	odd, even := palindromeRadii(word)
+
+	// nextSame[j] is the next index after j holding the same byte
+	nextSame := make([]int, len(word))
+	var lastSeen [256]int
+	for j := range lastSeen {
+		lastSeen[j] = len(word)
+	}
+	for j := len(word) - 1; j >= 0; j-- {
+		nextSame[j] = lastSeen[word[j]]
+		lastSeen[word[j]] = j
+	}
+
+	for j := 0; j+k <= len(word); j++ {
+		var palindrome bool
+		if k%2 == 1 {
+			palindrome = odd[j+k/2] >= (k+1)/2
+		} else {
+			palindrome = even[j+k/2] >= k/2
+		}
+		if palindrome && (!pd.innerMustDiffer || nextSame[j] == j+k-1) {
+			matches = append(matches, PalindromeMatch{j, word[j : j+k]})
+		}
+	}
+	return matches
+}
+
+func (pd PalindromeDetector) Any(word string) bool {
+	return len(pd.Find(word)) > 0
+}
+
+func stringHasAbbaNature(word string) bool {
+	return abbaDetector.Any(word)
 }
 
 func stringAbaOccurrences(word string) [][]byte {
@@ -34,11 +132,8 @@ func stringBabOccurrences(word string) [][]byte {
 
 func stringXyxOccurrences(word string, packer func(byte, byte) []byte) [][]byte {
 	rval := make([][]byte, 0, 10)
-	for j := 0; j < len(word)-2; j++ {
-		if word[j] == word[j+2] &&
-			word[j] != word[j+1] {
-			rval = append(rval, packer(word[j], word[j+1]))
-		}
+	for _, match := range abaDetector.Find(word) {
+		rval = append(rval, packer(match.window[0], match.window[1]))
 	}
 	return rval
 }
@@ -52,18 +147,83 @@ type IPv7 struct {
 	address string
 }
 
-var ipv7PartsRe = regexp.MustCompile(`(\b\w+\b)+`)
+// ----------------------------------------
+// tokenizing
 
-func (ip IPv7) parts() []IPv7Part {
-	matches := ipv7PartsRe.FindAllStringSubmatch(ip.address, -1)
-	parts := make([]IPv7Part, 0, 3)
+// IPv7Segment is a run of characters outside (supernet) or inside
+// (hypernet) square brackets, with its byte offset in the address.
+type IPv7Segment struct {
+	word       string
+	isHypernet bool
+	offset     int
+}
 
-	isHypernet := false
-	for _, match := range matches {
-		parts = append(parts, IPv7Part{match[0], isHypernet})
-		isHypernet = !isHypernet
+type IPv7Error struct {
+	address string
+	offset  int
+	reason  string
+}
+
+func (e IPv7Error) Error() string {
+	return fmt.Sprintf("address `%s`: offset %d: %s", e.address, e.offset, e.reason)
+}
+
+// tokenizeIPv7 splits an address by bracket position. It stops at the first
+// nested, empty or unbalanced bracket, returning the segments found so far
+// along with the error. Empty supernets, as in "a[b][c]d", are left out.
+func tokenizeIPv7(address string) ([]IPv7Segment, error) {
+	segments := make([]IPv7Segment, 0, 3)
+	fail := func(offset int, reason string) ([]IPv7Segment, error) {
+		return segments, IPv7Error{address, offset, reason}
 	}
 
+	start := 0
+	open := -1 // offset of the unclosed `[`, if any
+	for j := 0; j < len(address); j++ {
+		switch address[j] {
+		case '[':
+			if open >= 0 {
+				return fail(j, fmt.Sprintf("nested `[` inside hypernet opened at offset %d", open))
+			}
+			if j > start {
+				segments = append(segments, IPv7Segment{address[start:j], false, start})
+			}
+			open = j
+			start = j + 1
+		case ']':
+			if open < 0 {
+				return fail(j, "unbalanced `]`")
+			}
+			if j == start {
+				return fail(open, "empty hypernet")
+			}
+			segments = append(segments, IPv7Segment{address[start:j], true, start})
+			open = -1
+			start = j + 1
+		}
+	}
+	if open >= 0 {
+		return fail(open, "unbalanced `[`")
+	}
+	if len(address) > start {
+		segments = append(segments, IPv7Segment{address[start:], false, start})
+	}
+	return segments, nil
+}
+
+func (ip IPv7) validate() error {
+	_, err := tokenizeIPv7(ip.address)
+	return err
+}
+
+// parts is lenient: for a malformed address it returns the parts found
+// before the error. Use validate to find out what's wrong.
+func (ip IPv7) parts() []IPv7Part {
+	segments, _ := tokenizeIPv7(ip.address)
+	parts := make([]IPv7Part, len(segments))
+	for j, segment := range segments {
+		parts[j] = IPv7Part{segment.word, segment.isHypernet}
+	}
 	return parts
 }
 
@@ -107,6 +267,242 @@ func (ip IPv7) supportsSSL() bool {
 	return false
 }
 
+// ----------------------------------------
+// explanations
+
+type IPv7PatternMatch struct {
+	pattern    string
+	segment    string
+	isHypernet bool
+	offset     int // byte offset of the pattern in the whole address
+}
+
+func (m IPv7PatternMatch) String() string {
+	kind := "supernet"
+	if m.isHypernet {
+		kind = "hypernet"
+	}
+	return fmt.Sprintf("`%s` at offset %d in %s `%s`", m.pattern, m.offset, kind, m.segment)
+}
+
+type IPv7SSLPair struct {
+	aba IPv7PatternMatch
+	bab IPv7PatternMatch
+}
+
+type IPv7Verdict struct {
+	address  string
+	err      error // from validate; the verdict is still worked out from the lenient parts
+	tls      bool
+	enablers []IPv7PatternMatch // ABBAs in supernets
+	vetoes   []IPv7PatternMatch // ABBAs in hypernets
+	ssl      bool
+	sslPairs []IPv7SSLPair
+}
+
+func ipv7PatternMatches(segment IPv7Segment, pd PalindromeDetector) []IPv7PatternMatch {
+	matches := make([]IPv7PatternMatch, 0)
+	for _, match := range pd.Find(segment.word) {
+		matches = append(matches, IPv7PatternMatch{match.window, segment.word, segment.isHypernet, segment.offset + match.offset})
+	}
+	return matches
+}
+
+// explain works out the same answers as supportsTLS and supportsSSL, and
+// keeps track of why.
+func (ip IPv7) explain() IPv7Verdict {
+	segments, err := tokenizeIPv7(ip.address)
+	verdict := IPv7Verdict{address: ip.address, err: err}
+
+	abas := make([]IPv7PatternMatch, 0)
+	babs := make([]IPv7PatternMatch, 0)
+	for _, segment := range segments {
+		if segment.isHypernet {
+			verdict.vetoes = append(verdict.vetoes, ipv7PatternMatches(segment, abbaDetector)...)
+			babs = append(babs, ipv7PatternMatches(segment, abaDetector)...)
+		} else {
+			verdict.enablers = append(verdict.enablers, ipv7PatternMatches(segment, abbaDetector)...)
+			abas = append(abas, ipv7PatternMatches(segment, abaDetector)...)
+		}
+	}
+	verdict.tls = len(verdict.enablers) > 0 && len(verdict.vetoes) == 0
+
+	for _, aba := range abas {
+		for _, bab := range babs {
+			if aba.pattern[0] == bab.pattern[1] && aba.pattern[1] == bab.pattern[0] {
+				verdict.sslPairs = append(verdict.sslPairs, IPv7SSLPair{aba, bab})
+			}
+		}
+	}
+	verdict.ssl = len(verdict.sslPairs) > 0
+
+	return verdict
+}
+
+func (v IPv7Verdict) String() string {
+	output := bytes.Buffer{}
+	fmt.Fprintln(&output, v.address)
+	if v.err != nil {
+		fmt.Fprintln(&output, "  malformed:", v.err)
+	}
+
+	switch {
+	case v.tls:
+		fmt.Fprintln(&output, "  TLS: yes, enabled by", v.enablers[0])
+	case len(v.enablers) > 0:
+		fmt.Fprintln(&output, "  TLS: no,", v.vetoes[0], "vetoes", v.enablers[0])
+	case len(v.vetoes) > 0:
+		fmt.Fprintln(&output, "  TLS: no, no ABBA in any supernet, and", v.vetoes[0])
+	default:
+		fmt.Fprintln(&output, "  TLS: no, no ABBA in any supernet")
+	}
+
+	if v.ssl {
+		fmt.Fprintln(&output, "  SSL: yes,", v.sslPairs[0].aba, "matches", v.sslPairs[0].bab)
+	} else {
+		fmt.Fprintln(&output, "  SSL: no, no ABA in a supernet has a matching BAB in a hypernet")
+	}
+	return output.String()
+}
+
+// explainIPv7Addresses prints a verdict for each address, one per line of
+// input, skipping blank lines.
+func explainIPv7Addresses(input io.Reader, output io.Writer) error {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		address := strings.TrimSpace(scanner.Text())
+		if address == "" {
+			continue
+		}
+		if _, err := fmt.Fprint(output, IPv7{address}.explain()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+var ipv7Explain = flag.Bool("explain-ipv7", false, "print why each Day 7 address does or doesn't support TLS and SSL")
+
+// ----------------------------------------
+// generating
+
+const ipv7Letters = "abcdefghijklmnopqrstuvwxyz"
+const ipv7GeneratorAttempts = 100
+
+// IPv7Generator makes random well-formed addresses with a chosen TLS and SSL
+// support. Segments alternate supernet, hypernet, supernet, and so on.
+type IPv7Generator struct {
+	random   *rand.Rand
+	segments int
+	minLen   int
+	maxLen   int
+}
+
+func NewIPv7Generator(seed int64, segments, minLen, maxLen int) *IPv7Generator {
+	return &IPv7Generator{rand.New(rand.NewSource(seed)), segments, minLen, maxLen}
+}
+
+func (g *IPv7Generator) Generate(tls, ssl bool) (IPv7, error) {
+	switch {
+	case g.segments < 1 || g.minLen < 1 || g.maxLen < g.minLen:
+		return IPv7{}, fmt.Errorf("`%d` segments of length `%d` to `%d` make no address", g.segments, g.minLen, g.maxLen)
+	case tls && g.maxLen < 4:
+		return IPv7{}, fmt.Errorf("TLS needs segments of length 4 or more, not `%d`", g.maxLen)
+	case ssl && g.segments < 2:
+		return IPv7{}, fmt.Errorf("SSL needs a hypernet, so 2 segments or more, not `%d`", g.segments)
+	case ssl && g.maxLen < 3:
+		return IPv7{}, fmt.Errorf("SSL needs segments of length 3 or more, not `%d`", g.maxLen)
+	}
+
+	for attempt := 0; attempt < ipv7GeneratorAttempts; attempt++ {
+		ip := g.attempt(tls, ssl)
+		verdict := ip.explain()
+		if verdict.tls == tls && verdict.ssl == ssl {
+			return ip, nil
+		}
+	}
+	return IPv7{}, fmt.Errorf("no address with TLS `%t` and SSL `%t` after %d attempts", tls, ssl, ipv7GeneratorAttempts)
+}
+
+// attempt plants the patterns that are wanted and fills the rest with
+// letters that differ from their neighbours up to two away, which rules out
+// stray ABAs and ABBAs in the filler. Patterns planted next to each other
+// can still make one, so Generate checks the result.
+func (g *IPv7Generator) attempt(tls, ssl bool) IPv7 {
+	words := make([][]byte, g.segments)
+	for j := range words {
+		words[j] = make([]byte, g.minLen+g.random.Intn(g.maxLen-g.minLen+1))
+	}
+
+	a, b := g.letterPair()
+	switch {
+	case tls:
+		g.plant(words, false, []byte{a, b, b, a})
+	case g.segments > 1 && g.maxLen >= 4 && g.random.Intn(2) == 0:
+		// an ABBA vetoed by one in a hypernet
+		g.plant(words, false, []byte{a, b, b, a})
+		a, b = g.letterPair()
+		g.plant(words, true, []byte{a, b, b, a})
+	}
+	if ssl {
+		a, b = g.letterPair()
+		g.plant(words, false, []byte{a, b, a})
+		g.plant(words, true, []byte{b, a, b})
+	}
+
+	address := bytes.Buffer{}
+	for j, word := range words {
+		g.fill(word)
+		if j%2 == 1 {
+			address.WriteByte('[')
+			address.Write(word)
+			address.WriteByte(']')
+		} else {
+			address.Write(word)
+		}
+	}
+	return IPv7{address.String()}
+}
+
+func (g *IPv7Generator) letterPair() (byte, byte) {
+	a := g.random.Intn(len(ipv7Letters))
+	b := (a + 1 + g.random.Intn(len(ipv7Letters)-1)) % len(ipv7Letters)
+	return ipv7Letters[a], ipv7Letters[b]
+}
+
+// plant copies pattern into a random segment of the given kind, growing the
+// segment if it's too short.
+func (g *IPv7Generator) plant(words [][]byte, isHypernet bool, pattern []byte) {
+	j := 2 * g.random.Intn((len(words)+1)/2)
+	if isHypernet {
+		j = 2*g.random.Intn(len(words)/2) + 1
+	}
+	if len(words[j]) < len(pattern) {
+		words[j] = append(words[j], make([]byte, len(pattern)-len(words[j]))...)
+	}
+	copy(words[j][g.random.Intn(len(words[j])-len(pattern)+1):], pattern)
+}
+
+func (g *IPv7Generator) fill(word []byte) {
+	for j := range word {
+		if word[j] != 0 {
+			continue
+		}
+		for word[j] == 0 {
+			letter := ipv7Letters[g.random.Intn(len(ipv7Letters))]
+			clash := false
+			for k := j - 2; k <= j+2; k++ {
+				if k >= 0 && k < len(word) && word[k] == letter {
+					clash = true
+				}
+			}
+			if !clash {
+				word[j] = letter
+			}
+		}
+	}
+}
+
 var _ = Describe("Day7", func() {
 	Describe("#stringHasAbbaNature", func() {
 		It("looks for the abba pattern", func() {
@@ -151,6 +547,62 @@ var _ = Describe("Day7", func() {
 		})
 	})
 
+	Describe("PalindromeDetector", func() {
+		// the obvious quadratic version, for comparison
+		bruteForce := func(pd PalindromeDetector, word string) []PalindromeMatch {
+			matches := make([]PalindromeMatch, 0)
+			for j := 0; j+pd.length <= len(word); j++ {
+				window := word[j : j+pd.length]
+				ok := true
+				for i := 0; i < len(window); i++ {
+					if window[i] != window[len(window)-1-i] {
+						ok = false
+					}
+					if pd.innerMustDiffer && i > 0 && i < len(window)-1 && window[i] == window[0] {
+						ok = false
+					}
+				}
+				if ok {
+					matches = append(matches, PalindromeMatch{j, window})
+				}
+			}
+			return matches
+		}
+
+		It("finds windows of any length, with positions", func() {
+			Expect(PalindromeDetector{5, true}.Find("xabcbay")).To(Equal([]PalindromeMatch{{1, "abcba"}}))
+			Expect(PalindromeDetector{6, true}.Find("abccbaxyzzyx")).To(Equal([]PalindromeMatch{
+				{0, "abccba"}, {6, "xyzzyx"},
+			}))
+			Expect(PalindromeDetector{2, false}.Find("aabb")).To(Equal([]PalindromeMatch{{0, "aa"}, {2, "bb"}}))
+		})
+
+		It("can require inner characters to differ from the outer ones", func() {
+			Expect(PalindromeDetector{4, false}.Find("aaaa")).To(HaveLen(1))
+			Expect(PalindromeDetector{4, true}.Find("aaaa")).To(BeEmpty())
+			Expect(PalindromeDetector{5, true}.Find("abaab")).To(BeEmpty())
+			Expect(PalindromeDetector{5, false}.Find("ababa")).To(Equal([]PalindromeMatch{{0, "ababa"}}))
+			Expect(PalindromeDetector{5, true}.Find("ababa")).To(BeEmpty())
+		})
+
+		It("handles short words", func() {
+			Expect(abbaDetector.Find("")).To(BeEmpty())
+			Expect(abbaDetector.Find("abb")).To(BeEmpty())
+		})
+
+		It("agrees with the brute-force search", func() {
+			random := rand.New(rand.NewSource(7))
+			for trial := 0; trial < 500; trial++ {
+				word := make([]byte, random.Intn(30))
+				for j := range word {
+					word[j] = "abc"[random.Intn(3)]
+				}
+				pd := PalindromeDetector{2 + random.Intn(6), random.Intn(2) == 0}
+				Expect(pd.Find(string(word))).To(Equal(bruteForce(pd, string(word))), string(word))
+			}
+		})
+	})
+
 	Describe("IPv7", func() {
 		Describe("#parts", func() {
 			Context("address has three parts", func() {
@@ -174,6 +626,44 @@ var _ = Describe("Day7", func() {
 			})
 		})
 
+		Describe("#validate", func() {
+			It("accepts well-formed addresses", func() {
+				Expect(IPv7{"abba[mnop]qrst"}.validate()).To(Succeed())
+				Expect(IPv7{"[mnop]qrst[abc]"}.validate()).To(Succeed())
+				Expect(IPv7{"abcd"}.validate()).To(Succeed())
+			})
+
+			It("reports nesting", func() {
+				Expect(IPv7{"a[b[c]]d"}.validate()).To(MatchError(
+					"address `a[b[c]]d`: offset 3: nested `[` inside hypernet opened at offset 1"))
+			})
+
+			It("reports empty hypernets", func() {
+				Expect(IPv7{"abc[]def"}.validate()).To(MatchError("address `abc[]def`: offset 3: empty hypernet"))
+			})
+
+			It("reports unbalanced brackets", func() {
+				Expect(IPv7{"abc]def"}.validate()).To(MatchError("address `abc]def`: offset 3: unbalanced `]`"))
+				Expect(IPv7{"abc[def"}.validate()).To(MatchError("address `abc[def`: offset 3: unbalanced `[`"))
+			})
+		})
+
+		Describe("tokenizeIPv7", func() {
+			It("classifies segments by bracket position, with offsets", func() {
+				segments, err := tokenizeIPv7("[ab]cd[ef][gh]ij")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(segments).To(Equal([]IPv7Segment{
+					{"ab", true, 1}, {"cd", false, 4}, {"ef", true, 7}, {"gh", true, 11}, {"ij", false, 14},
+				}))
+			})
+
+			It("returns the segments found before an error", func() {
+				segments, err := tokenizeIPv7("ab[cd]ef[gh")
+				Expect(err).To(HaveOccurred())
+				Expect(segments).To(Equal([]IPv7Segment{{"ab", false, 0}, {"cd", true, 3}, {"ef", false, 6}}))
+			})
+		})
+
 		Describe("#supportsTLS", func() {
 			It("parses the address to determine support", func() {
 				Expect(IPv7{"abba[mnop]qrst"}.supportsTLS()).To(BeTrue())
@@ -189,6 +679,115 @@ var _ = Describe("Day7", func() {
 			})
 		})
 
+		Describe("#explain", func() {
+			It("names the supernet ABBA that enabled TLS", func() {
+				verdict := IPv7{"qrst[mnop]abba"}.explain()
+				Expect(verdict.tls).To(BeTrue())
+				Expect(verdict.enablers).To(Equal([]IPv7PatternMatch{{"abba", "abba", false, 10}}))
+				Expect(verdict.vetoes).To(BeEmpty())
+			})
+
+			It("names the hypernet ABBA that vetoed TLS", func() {
+				verdict := IPv7{"abcd[bddb]xyyx"}.explain()
+				Expect(verdict.tls).To(BeFalse())
+				Expect(verdict.enablers).To(Equal([]IPv7PatternMatch{{"xyyx", "xyyx", false, 10}}))
+				Expect(verdict.vetoes).To(Equal([]IPv7PatternMatch{{"bddb", "bddb", true, 5}}))
+			})
+
+			It("names the ABA/BAB pairs that matched for SSL", func() {
+				verdict := IPv7{"zazbz[bzb]cdb"}.explain()
+				Expect(verdict.ssl).To(BeTrue())
+				Expect(verdict.sslPairs).To(Equal([]IPv7SSLPair{
+					{IPv7PatternMatch{"zbz", "zazbz", false, 2}, IPv7PatternMatch{"bzb", "bzb", true, 6}},
+				}))
+			})
+
+			It("explains itself in words", func() {
+				Expect(IPv7{"abcd[bddb]xyyx"}.explain().String()).To(Equal("abcd[bddb]xyyx\n" +
+					"  TLS: no, `bddb` at offset 5 in hypernet `bddb` vetoes `xyyx` at offset 10 in supernet `xyyx`\n" +
+					"  SSL: no, no ABA in a supernet has a matching BAB in a hypernet\n"))
+				Expect(IPv7{"aba[bab]xyz"}.explain().String()).To(Equal("aba[bab]xyz\n" +
+					"  TLS: no, no ABBA in any supernet\n" +
+					"  SSL: yes, `aba` at offset 0 in supernet `aba` matches `bab` at offset 4 in hypernet `bab`\n"))
+			})
+
+			It("mentions malformed addresses", func() {
+				Expect(IPv7{"ab[ba"}.explain().String()).To(ContainSubstring("  malformed: address `ab[ba`: offset 2: unbalanced `[`\n"))
+			})
+
+			It("explains a batch of addresses", func() {
+				output := bytes.Buffer{}
+				Expect(explainIPv7Addresses(strings.NewReader("abba[mnop]qrst\n\naaaa[qwer]tyui\n"), &output)).To(Succeed())
+				Expect(strings.Count(output.String(), "TLS:")).To(Equal(2))
+				Expect(output.String()).To(ContainSubstring("TLS: yes, enabled by `abba` at offset 0 in supernet `abba`"))
+			})
+		})
+
+		Describe("IPv7Generator", func() {
+			shapes := []struct{ segments, minLen, maxLen int }{
+				{2, 3, 3}, {3, 4, 8}, {5, 1, 6}, {7, 2, 12}, {4, 8, 20},
+			}
+			wants := []struct{ tls, ssl bool }{
+				{false, false}, {true, false}, {false, true}, {true, true},
+			}
+
+			It("makes well-formed addresses of the requested shape and support", func() {
+				for _, shape := range shapes {
+					generator := NewIPv7Generator(int64(shape.segments), shape.segments, shape.minLen, shape.maxLen)
+					for _, want := range wants {
+						if want.tls && shape.maxLen < 4 {
+							continue
+						}
+						for j := 0; j < 50; j++ {
+							ip, err := generator.Generate(want.tls, want.ssl)
+							Expect(err).NotTo(HaveOccurred())
+							Expect(ip.validate()).To(Succeed())
+							Expect(ip.supportsTLS()).To(Equal(want.tls), ip.address)
+							Expect(ip.supportsSSL()).To(Equal(want.ssl), ip.address)
+
+							segments, _ := tokenizeIPv7(ip.address)
+							Expect(segments).To(HaveLen(shape.segments), ip.address)
+							for _, segment := range segments {
+								Expect(len(segment.word)).To(BeNumerically(">=", shape.minLen), ip.address)
+								Expect(len(segment.word)).To(BeNumerically("<=", shape.maxLen), ip.address)
+								Expect(segment.isHypernet).To(Equal(segment.offset > 0 && ip.address[segment.offset-1] == '['))
+							}
+						}
+					}
+				}
+			})
+
+			It("sometimes vetoes a supernet ABBA when TLS is unwanted", func() {
+				generator := NewIPv7Generator(1, 3, 4, 8)
+				vetoed := 0
+				for j := 0; j < 50; j++ {
+					ip, err := generator.Generate(false, false)
+					Expect(err).NotTo(HaveOccurred())
+					if len(ip.explain().vetoes) > 0 {
+						vetoed++
+					}
+				}
+				Expect(vetoed).To(BeNumerically(">", 0))
+			})
+
+			It("is repeatable for a seed", func() {
+				first, _ := NewIPv7Generator(42, 5, 3, 9).Generate(true, true)
+				second, _ := NewIPv7Generator(42, 5, 3, 9).Generate(true, true)
+				Expect(first).To(Equal(second))
+			})
+
+			It("refuses requests it can't meet", func() {
+				_, err := NewIPv7Generator(1, 3, 1, 3).Generate(true, false)
+				Expect(err).To(MatchError("TLS needs segments of length 4 or more, not `3`"))
+				_, err = NewIPv7Generator(1, 1, 4, 8).Generate(false, true)
+				Expect(err).To(MatchError("SSL needs a hypernet, so 2 segments or more, not `1`"))
+				_, err = NewIPv7Generator(1, 3, 2, 2).Generate(false, true)
+				Expect(err).To(MatchError("SSL needs segments of length 3 or more, not `2`"))
+				_, err = NewIPv7Generator(1, 0, 4, 8).Generate(false, false)
+				Expect(err).To(MatchError("`0` segments of length `4` to `8` make no address"))
+			})
+		})
+
 		Describe("#supportsSSL", func() {
 			It("finds matching aba-in-supernet and bab-in-hypernet", func() {
 				Expect(IPv7{"aba[bab]xyz"}.supportsSSL()).To(BeTrue())
@@ -206,6 +805,29 @@ var _ = Describe("Day7", func() {
 		}
 		addresses := parseFile("day7.txt")
 
+		Specify("every address is well-formed", func() {
+			for _, address := range addresses {
+				Expect(IPv7{address}.validate()).To(Succeed())
+			}
+		})
+
+		Specify("explanations agree with supportsTLS and supportsSSL", func() {
+			for _, address := range addresses {
+				verdict := IPv7{address}.explain()
+				Expect(verdict.tls).To(Equal(IPv7{address}.supportsTLS()), address)
+				Expect(verdict.ssl).To(Equal(IPv7{address}.supportsSSL()), address)
+			}
+		})
+
+		Specify("explain every address, with -explain-ipv7", func() {
+			if *ipv7Explain {
+				file, err := os.Open("day7.txt")
+				Expect(err).NotTo(HaveOccurred())
+				defer file.Close()
+				Expect(explainIPv7Addresses(file, os.Stdout)).To(Succeed())
+			}
+		})
+
 		Describe("star 1", func() {
 			Specify("count the addresses that support TLS", func() {
 				nMatches := 0
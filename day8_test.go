@@ -1,10 +1,22 @@
 package adventofcode2016_test
 
 import (
+	"bufio"
+	"bytes"
+	"flag"
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
 	"io/ioutil"
+	"math/bits"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -14,6 +26,13 @@ type TinyDisplayCommand interface {
 	Rect(int, int)
 	RotateCol(int, int)
 	RotateRow(int, int)
+	RectAt(int, int, int, int)
+	Clear()
+	Invert(int, int, int, int)
+	ShiftCol(int, int)
+	ShiftRow(int, int)
+	CopyCol(int, int)
+	CopyRow(int, int)
 }
 
 type TinyDisplay struct {
@@ -30,14 +49,44 @@ func NewTinyDisplay(xSize, ySize int) TinyDisplay {
 }
 
 func (td *TinyDisplay) Rect(xSize, ySize int) {
-	for y := 0; y < ySize; y++ {
-		for x := 0; x < xSize; x++ {
-			td.pixels[y][x] = true
+	td.RectAt(0, 0, xSize, ySize)
+}
+
+func (td *TinyDisplay) RectAt(x, y, xSize, ySize int) {
+	for y0 := y; y0 < y+ySize; y0++ {
+		for x0 := x; x0 < x+xSize; x0++ {
+			td.pixels[y0][x0] = true
+		}
+	}
+}
+
+func (td *TinyDisplay) Clear() {
+	for _, row := range td.pixels {
+		for x := range row {
+			row[x] = false
 		}
 	}
 }
 
+func (td *TinyDisplay) Invert(x, y, xSize, ySize int) {
+	for y0 := y; y0 < y+ySize; y0++ {
+		for x0 := x; x0 < x+xSize; x0++ {
+			td.pixels[y0][x0] = !td.pixels[y0][x0]
+		}
+	}
+}
+
+// tdWrap turns a rotation of any sign into the equivalent one to the right
+// or down. Nothing rotates on a display with no width or height.
+func tdWrap(len, size int) int {
+	if size == 0 {
+		return 0
+	}
+	return (len%size + size) % size
+}
+
 func (td *TinyDisplay) RotateCol(colIndex, len int) {
+	len = tdWrap(len, td.ySize)
 	for times := 0; times < len; times++ {
 		bottomPixel := td.pixels[td.ySize-1][colIndex]
 		for y := td.ySize - 1; y > 0; y-- {
@@ -49,6 +98,7 @@ func (td *TinyDisplay) RotateCol(colIndex, len int) {
 
 func (td *TinyDisplay) RotateRow(rowIndex, len int) {
 	row := td.pixels[rowIndex]
+	len = tdWrap(len, td.xSize)
 	for times := 0; times < len; times++ {
 		rightPixel := row[td.xSize-1]
 		for x := td.xSize - 1; x > 0; x-- {
@@ -58,6 +108,49 @@ func (td *TinyDisplay) RotateRow(rowIndex, len int) {
 	}
 }
 
+// ShiftCol moves a column down, or up for a negative len, dropping the
+// pixels that fall off the edge and leaving the vacated ones off.
+func (td *TinyDisplay) ShiftCol(colIndex, len int) {
+	column := make([]bool, td.ySize)
+	for y := range column {
+		if from := y - len; from >= 0 && from < td.ySize {
+			column[y] = td.pixels[from][colIndex]
+		}
+	}
+	for y, pixel := range column {
+		td.pixels[y][colIndex] = pixel
+	}
+}
+
+// ShiftRow moves a row right, or left for a negative len, like ShiftCol.
+func (td *TinyDisplay) ShiftRow(rowIndex, len int) {
+	row := make([]bool, td.xSize)
+	for x := range row {
+		if from := x - len; from >= 0 && from < td.xSize {
+			row[x] = td.pixels[rowIndex][from]
+		}
+	}
+	td.pixels[rowIndex] = row
+}
+
+func (td *TinyDisplay) CopyCol(fromIndex, toIndex int) {
+	for _, row := range td.pixels {
+		row[toIndex] = row[fromIndex]
+	}
+}
+
+func (td *TinyDisplay) CopyRow(fromIndex, toIndex int) {
+	copy(td.pixels[toIndex], td.pixels[fromIndex])
+}
+
+func (td TinyDisplay) Size() (int, int) {
+	return td.xSize, td.ySize
+}
+
+func (td TinyDisplay) Pixel(x, y int) bool {
+	return td.pixels[y][x]
+}
+
 func (td TinyDisplay) String() string {
 	output := "\n"
 	for _, row := range td.pixels {
@@ -76,24 +169,108 @@ func (td TinyDisplay) String() string {
 //
 //  command input
 //
-var tdRectCommandRe = regexp.MustCompile(`rect (\d+)x(\d+)`)
-var tdRotateColCommandRe = regexp.MustCompile(`rotate column x=(\d+) by (\d+)`)
-var tdRotateRowCommandRe = regexp.MustCompile(`rotate row y=(\d+) by (\d+)`)
-
-func TDCommandDispatch(command string, subject TinyDisplayCommand) {
-	if match := tdRectCommandRe.FindStringSubmatch(command); match != nil {
-		arg1, _ := strconv.Atoi(match[1])
-		arg2, _ := strconv.Atoi(match[2])
-		subject.Rect(arg1, arg2)
-	} else if match := tdRotateColCommandRe.FindStringSubmatch(command); match != nil {
-		arg1, _ := strconv.Atoi(match[1])
-		arg2, _ := strconv.Atoi(match[2])
-		subject.RotateCol(arg1, arg2)
-	} else if match := tdRotateRowCommandRe.FindStringSubmatch(command); match != nil {
-		arg1, _ := strconv.Atoi(match[1])
-		arg2, _ := strconv.Atoi(match[2])
-		subject.RotateRow(arg1, arg2)
+// tdCommand is one line of a display program. fits, if not nil, says
+// whether the command's rows, columns and rectangles are on a display of
+// the given size.
+type tdCommand struct {
+	re    *regexp.Regexp
+	fits  func(args []int, xSize, ySize int) bool
+	apply func(TinyDisplayCommand, []int)
+}
+
+// tdSized is a subject that can say how big it is, so TDCommandDispatch can
+// check commands against it. MockTD can't, and takes anything.
+type tdSized interface {
+	Size() (int, int)
+}
+
+// tdFitsRect checks a rectangle without adding, so huge arguments can't
+// overflow.
+func tdFitsRect(x, y, xSize, ySize, displayXSize, displayYSize int) bool {
+	return x <= displayXSize && xSize <= displayXSize-x && y <= displayYSize && ySize <= displayYSize-y
+}
+
+var tdCommands = []tdCommand{
+	{regexp.MustCompile(`^rect (\d+)x(\d+)$`), func(args []int, xSize, ySize int) bool {
+		return tdFitsRect(0, 0, args[0], args[1], xSize, ySize)
+	}, func(subject TinyDisplayCommand, args []int) {
+		subject.Rect(args[0], args[1])
+	}},
+	{regexp.MustCompile(`^rect (\d+)x(\d+) at (\d+),(\d+)$`), func(args []int, xSize, ySize int) bool {
+		return tdFitsRect(args[2], args[3], args[0], args[1], xSize, ySize)
+	}, func(subject TinyDisplayCommand, args []int) {
+		subject.RectAt(args[2], args[3], args[0], args[1])
+	}},
+	{regexp.MustCompile(`^clear$`), nil, func(subject TinyDisplayCommand, args []int) {
+		subject.Clear()
+	}},
+	{regexp.MustCompile(`^invert (\d+)x(\d+) at (\d+),(\d+)$`), func(args []int, xSize, ySize int) bool {
+		return tdFitsRect(args[2], args[3], args[0], args[1], xSize, ySize)
+	}, func(subject TinyDisplayCommand, args []int) {
+		subject.Invert(args[2], args[3], args[0], args[1])
+	}},
+	{regexp.MustCompile(`^rotate column x=(\d+) by (-?\d+)$`), func(args []int, xSize, ySize int) bool {
+		return args[0] < xSize
+	}, func(subject TinyDisplayCommand, args []int) {
+		subject.RotateCol(args[0], args[1])
+	}},
+	{regexp.MustCompile(`^rotate row y=(\d+) by (-?\d+)$`), func(args []int, xSize, ySize int) bool {
+		return args[0] < ySize
+	}, func(subject TinyDisplayCommand, args []int) {
+		subject.RotateRow(args[0], args[1])
+	}},
+	{regexp.MustCompile(`^shift column x=(\d+) by (-?\d+)$`), func(args []int, xSize, ySize int) bool {
+		return args[0] < xSize
+	}, func(subject TinyDisplayCommand, args []int) {
+		subject.ShiftCol(args[0], args[1])
+	}},
+	{regexp.MustCompile(`^shift row y=(\d+) by (-?\d+)$`), func(args []int, xSize, ySize int) bool {
+		return args[0] < ySize
+	}, func(subject TinyDisplayCommand, args []int) {
+		subject.ShiftRow(args[0], args[1])
+	}},
+	{regexp.MustCompile(`^copy column x=(\d+) to x=(\d+)$`), func(args []int, xSize, ySize int) bool {
+		return args[0] < xSize && args[1] < xSize
+	}, func(subject TinyDisplayCommand, args []int) {
+		subject.CopyCol(args[0], args[1])
+	}},
+	{regexp.MustCompile(`^copy row y=(\d+) to y=(\d+)$`), func(args []int, xSize, ySize int) bool {
+		return args[0] < ySize && args[1] < ySize
+	}, func(subject TinyDisplayCommand, args []int) {
+		subject.CopyRow(args[0], args[1])
+	}},
+}
+
+// TDCommandDispatch applies one line of a display program to the subject.
+// Blank lines do nothing; anything else that isn't a command is an error,
+// as is a command that reaches off a subject that knows its size.
+func TDCommandDispatch(command string, subject TinyDisplayCommand) error {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return nil
+	}
+	for _, candidate := range tdCommands {
+		match := candidate.re.FindStringSubmatch(command)
+		if match == nil {
+			continue
+		}
+		args := make([]int, len(match)-1)
+		for j, text := range match[1:] {
+			arg, err := strconv.Atoi(text)
+			if err != nil {
+				return fmt.Errorf("command `%s`: %s", command, err)
+			}
+			args[j] = arg
+		}
+		if sized, ok := subject.(tdSized); ok && candidate.fits != nil {
+			if xSize, ySize := sized.Size(); !candidate.fits(args, xSize, ySize) {
+				return fmt.Errorf("command `%s` reaches off the %dx%d display", command, xSize, ySize)
+			}
+		}
+		candidate.apply(subject, args)
+		return nil
 	}
+	return fmt.Errorf("unrecognized command `%s`", command)
 }
 
 //
@@ -103,6 +280,8 @@ type MockTD struct {
 	method string
 	arg1   int
 	arg2   int
+	arg3   int
+	arg4   int
 }
 
 func (mtd *MockTD) Rect(arg1 int, arg2 int) {
@@ -123,6 +302,663 @@ func (mtd *MockTD) RotateRow(arg1 int, arg2 int) {
 	mtd.arg2 = arg2
 }
 
+func (mtd *MockTD) RectAt(arg1, arg2, arg3, arg4 int) {
+	mtd.method = "RectAt"
+	mtd.arg1 = arg1
+	mtd.arg2 = arg2
+	mtd.arg3 = arg3
+	mtd.arg4 = arg4
+}
+
+func (mtd *MockTD) Invert(arg1, arg2, arg3, arg4 int) {
+	mtd.method = "Invert"
+	mtd.arg1 = arg1
+	mtd.arg2 = arg2
+	mtd.arg3 = arg3
+	mtd.arg4 = arg4
+}
+
+func (mtd *MockTD) Clear() {
+	mtd.method = "Clear"
+}
+
+func (mtd *MockTD) ShiftCol(arg1 int, arg2 int) {
+	mtd.method = "ShiftCol"
+	mtd.arg1 = arg1
+	mtd.arg2 = arg2
+}
+
+func (mtd *MockTD) ShiftRow(arg1 int, arg2 int) {
+	mtd.method = "ShiftRow"
+	mtd.arg1 = arg1
+	mtd.arg2 = arg2
+}
+
+func (mtd *MockTD) CopyCol(arg1 int, arg2 int) {
+	mtd.method = "CopyCol"
+	mtd.arg1 = arg1
+	mtd.arg2 = arg2
+}
+
+func (mtd *MockTD) CopyRow(arg1 int, arg2 int) {
+	mtd.method = "CopyRow"
+	mtd.arg1 = arg1
+	mtd.arg2 = arg2
+}
+
+// ----------------------------------------
+// bitset backend
+
+// BitsetTinyDisplay packs each row into 64-bit words, pixel x at bit x%64 of
+// word x/64, so a row rotates by any amount in a few word operations and a
+// column in one pass down the rows, instead of one pixel step at a time.
+type BitsetTinyDisplay struct {
+	xSize, ySize int
+	rows         [][]uint64
+}
+
+func NewBitsetTinyDisplay(xSize, ySize int) *BitsetTinyDisplay {
+	bd := &BitsetTinyDisplay{xSize, ySize, make([][]uint64, ySize)}
+	for y := range bd.rows {
+		bd.rows[y] = make([]uint64, (xSize+63)/64)
+	}
+	return bd
+}
+
+// TinyDisplayBackend is a display that can be driven by commands and read
+// back, whatever its storage.
+type TinyDisplayBackend interface {
+	TinyDisplayCommand
+	Size() (int, int)
+	Pixel(x, y int) bool
+	String() string
+}
+
+var tdBackends = map[string]func(int, int) TinyDisplayBackend{
+	"pixels": func(xSize, ySize int) TinyDisplayBackend {
+		td := NewTinyDisplay(xSize, ySize)
+		return &td
+	},
+	"bitset": func(xSize, ySize int) TinyDisplayBackend {
+		return NewBitsetTinyDisplay(xSize, ySize)
+	},
+}
+
+// NewTinyDisplayWithBackend picks a backend by name: "pixels" for the
+// straightforward one, "bitset" for very large displays.
+func NewTinyDisplayWithBackend(backend string, xSize, ySize int) (TinyDisplayBackend, error) {
+	if newDisplay, ok := tdBackends[backend]; ok {
+		return newDisplay(xSize, ySize), nil
+	}
+	return nil, fmt.Errorf("unknown display backend `%s`", backend)
+}
+
+func (bd *BitsetTinyDisplay) Size() (int, int) {
+	return bd.xSize, bd.ySize
+}
+
+func (bd *BitsetTinyDisplay) Pixel(x, y int) bool {
+	return bd.rows[y][x/64]&(1<<uint(x%64)) != 0
+}
+
+func (bd *BitsetTinyDisplay) setPixel(x, y int, pixel bool) {
+	if pixel {
+		bd.rows[y][x/64] |= 1 << uint(x%64)
+	} else {
+		bd.rows[y][x/64] &^= 1 << uint(x%64)
+	}
+}
+
+// rangeMask has the bits of word w that fall in [x0, x1).
+func rangeMask(w, x0, x1 int) uint64 {
+	lo, hi := x0-64*w, x1-64*w
+	if lo < 0 {
+		lo = 0
+	}
+	if hi > 64 {
+		hi = 64
+	}
+	if lo >= hi {
+		return 0
+	}
+	return (^uint64(0) >> uint(64-(hi-lo))) << uint(lo)
+}
+
+// shifted returns a row with pixel x taken from pixel x-n of row, or off if
+// that's outside the display.
+func (bd *BitsetTinyDisplay) shifted(row []uint64, n int) []uint64 {
+	out := make([]uint64, len(row))
+	if n >= bd.xSize || -n >= bd.xSize {
+		return out
+	}
+	word := func(w int) uint64 {
+		if w < 0 || w >= len(row) {
+			return 0
+		}
+		return row[w]
+	}
+	if n >= 0 {
+		ws, bs := n/64, uint(n%64)
+		for w := range out {
+			out[w] = word(w-ws) << bs
+			if bs > 0 {
+				out[w] |= word(w-ws-1) >> (64 - bs)
+			}
+		}
+	} else {
+		ws, bs := -n/64, uint(-n%64)
+		for w := range out {
+			out[w] = word(w+ws) >> bs
+			if bs > 0 {
+				out[w] |= word(w+ws+1) << (64 - bs)
+			}
+		}
+	}
+	out[len(out)-1] &= rangeMask(len(out)-1, 0, bd.xSize)
+	return out
+}
+
+func (bd *BitsetTinyDisplay) Rect(xSize, ySize int) {
+	bd.RectAt(0, 0, xSize, ySize)
+}
+
+func (bd *BitsetTinyDisplay) RectAt(x, y, xSize, ySize int) {
+	for y0 := y; y0 < y+ySize; y0++ {
+		for w := range bd.rows[y0] {
+			bd.rows[y0][w] |= rangeMask(w, x, x+xSize)
+		}
+	}
+}
+
+func (bd *BitsetTinyDisplay) Clear() {
+	for _, row := range bd.rows {
+		for w := range row {
+			row[w] = 0
+		}
+	}
+}
+
+func (bd *BitsetTinyDisplay) Invert(x, y, xSize, ySize int) {
+	for y0 := y; y0 < y+ySize; y0++ {
+		for w := range bd.rows[y0] {
+			bd.rows[y0][w] ^= rangeMask(w, x, x+xSize)
+		}
+	}
+}
+
+func (bd *BitsetTinyDisplay) RotateRow(rowIndex, len int) {
+	len = tdWrap(len, bd.xSize)
+	row := bd.rows[rowIndex]
+	right, wrapped := bd.shifted(row, len), bd.shifted(row, len-bd.xSize)
+	for w := range row {
+		row[w] = right[w] | wrapped[w]
+	}
+}
+
+func (bd *BitsetTinyDisplay) ShiftRow(rowIndex, len int) {
+	bd.rows[rowIndex] = bd.shifted(bd.rows[rowIndex], len)
+}
+
+func (bd *BitsetTinyDisplay) column(colIndex int) []bool {
+	column := make([]bool, bd.ySize)
+	for y := range column {
+		column[y] = bd.Pixel(colIndex, y)
+	}
+	return column
+}
+
+func (bd *BitsetTinyDisplay) RotateCol(colIndex, len int) {
+	column := bd.column(colIndex)
+	for y, pixel := range column {
+		bd.setPixel(colIndex, (y+tdWrap(len, bd.ySize))%bd.ySize, pixel)
+	}
+}
+
+func (bd *BitsetTinyDisplay) ShiftCol(colIndex, len int) {
+	column := bd.column(colIndex)
+	for y := range column {
+		from := y - len
+		bd.setPixel(colIndex, y, from >= 0 && from < bd.ySize && column[from])
+	}
+}
+
+func (bd *BitsetTinyDisplay) CopyCol(fromIndex, toIndex int) {
+	for y := range bd.rows {
+		bd.setPixel(toIndex, y, bd.Pixel(fromIndex, y))
+	}
+}
+
+func (bd *BitsetTinyDisplay) CopyRow(fromIndex, toIndex int) {
+	copy(bd.rows[toIndex], bd.rows[fromIndex])
+}
+
+func (bd *BitsetTinyDisplay) Lit() int {
+	lit := 0
+	for _, row := range bd.rows {
+		for _, word := range row {
+			lit += bits.OnesCount64(word)
+		}
+	}
+	return lit
+}
+
+// TinyDisplay copies the pixels out, for reading letters or making images.
+func (bd *BitsetTinyDisplay) TinyDisplay() TinyDisplay {
+	td := NewTinyDisplay(bd.xSize, bd.ySize)
+	for y, row := range td.pixels {
+		for x := range row {
+			row[x] = bd.Pixel(x, y)
+		}
+	}
+	return td
+}
+
+func (bd *BitsetTinyDisplay) String() string {
+	output := bytes.Buffer{}
+	output.WriteByte('\n')
+	for y := 0; y < bd.ySize; y++ {
+		for x := 0; x < bd.xSize; x++ {
+			if bd.Pixel(x, y) {
+				output.WriteByte('#')
+			} else {
+				output.WriteByte('.')
+			}
+		}
+		output.WriteByte('\n')
+	}
+	return output.String()
+}
+
+// ----------------------------------------
+// command history
+
+type tdSavedPixel struct {
+	x, y  int
+	pixel bool
+}
+
+type tdHistoryStep struct {
+	command string
+	apply   func()
+	undo    func()
+}
+
+// TinyDisplayHistory applies commands to a display, keeping what it needs to
+// take each one back. Rotations and inversions undo themselves with the
+// opposite command; everything else saves the pixels it overwrites.
+type TinyDisplayHistory struct {
+	display  TinyDisplayBackend
+	steps    []tdHistoryStep
+	position int // the number of steps applied
+}
+
+func NewTinyDisplayHistory(display TinyDisplayBackend) *TinyDisplayHistory {
+	return &TinyDisplayHistory{display: display}
+}
+
+func (h *TinyDisplayHistory) Size() (int, int) {
+	return h.display.Size()
+}
+
+// do applies a new step, dropping any steps that were undone before it.
+func (h *TinyDisplayHistory) do(command string, apply, undo func()) {
+	h.steps = append(h.steps[:h.position], tdHistoryStep{command, apply, undo})
+	h.position++
+	apply()
+}
+
+// save keeps the pixels in a region, or just the lit ones.
+func (h *TinyDisplayHistory) save(x, y, xSize, ySize int, litOnly bool) []tdSavedPixel {
+	saved := make([]tdSavedPixel, 0)
+	for y0 := y; y0 < y+ySize; y0++ {
+		for x0 := x; x0 < x+xSize; x0++ {
+			if pixel := h.display.Pixel(x0, y0); pixel || !litOnly {
+				saved = append(saved, tdSavedPixel{x0, y0, pixel})
+			}
+		}
+	}
+	return saved
+}
+
+// restore puts saved pixels back, flipping the ones that changed.
+func (h *TinyDisplayHistory) restore(saved []tdSavedPixel) {
+	for _, p := range saved {
+		if h.display.Pixel(p.x, p.y) != p.pixel {
+			h.display.Invert(p.x, p.y, 1, 1)
+		}
+	}
+}
+
+func (h *TinyDisplayHistory) overwrite(command string, saved []tdSavedPixel, apply func()) {
+	h.do(command, apply, func() { h.restore(saved) })
+}
+
+func (h *TinyDisplayHistory) Rect(xSize, ySize int) {
+	h.overwrite(fmt.Sprintf("rect %dx%d", xSize, ySize), h.save(0, 0, xSize, ySize, false), func() {
+		h.display.Rect(xSize, ySize)
+	})
+}
+
+func (h *TinyDisplayHistory) RectAt(x, y, xSize, ySize int) {
+	h.overwrite(fmt.Sprintf("rect %dx%d at %d,%d", xSize, ySize, x, y), h.save(x, y, xSize, ySize, false), func() {
+		h.display.RectAt(x, y, xSize, ySize)
+	})
+}
+
+func (h *TinyDisplayHistory) Clear() {
+	xSize, ySize := h.display.Size()
+	h.overwrite("clear", h.save(0, 0, xSize, ySize, true), h.display.Clear)
+}
+
+func (h *TinyDisplayHistory) Invert(x, y, xSize, ySize int) {
+	invert := func() { h.display.Invert(x, y, xSize, ySize) }
+	h.do(fmt.Sprintf("invert %dx%d at %d,%d", xSize, ySize, x, y), invert, invert)
+}
+
+func (h *TinyDisplayHistory) RotateCol(colIndex, len int) {
+	h.do(fmt.Sprintf("rotate column x=%d by %d", colIndex, len),
+		func() { h.display.RotateCol(colIndex, len) },
+		func() { h.display.RotateCol(colIndex, -len) })
+}
+
+func (h *TinyDisplayHistory) RotateRow(rowIndex, len int) {
+	h.do(fmt.Sprintf("rotate row y=%d by %d", rowIndex, len),
+		func() { h.display.RotateRow(rowIndex, len) },
+		func() { h.display.RotateRow(rowIndex, -len) })
+}
+
+func (h *TinyDisplayHistory) ShiftCol(colIndex, len int) {
+	_, ySize := h.display.Size()
+	h.overwrite(fmt.Sprintf("shift column x=%d by %d", colIndex, len), h.save(colIndex, 0, 1, ySize, false), func() {
+		h.display.ShiftCol(colIndex, len)
+	})
+}
+
+func (h *TinyDisplayHistory) ShiftRow(rowIndex, len int) {
+	xSize, _ := h.display.Size()
+	h.overwrite(fmt.Sprintf("shift row y=%d by %d", rowIndex, len), h.save(0, rowIndex, xSize, 1, false), func() {
+		h.display.ShiftRow(rowIndex, len)
+	})
+}
+
+func (h *TinyDisplayHistory) CopyCol(fromIndex, toIndex int) {
+	_, ySize := h.display.Size()
+	h.overwrite(fmt.Sprintf("copy column x=%d to x=%d", fromIndex, toIndex), h.save(toIndex, 0, 1, ySize, false), func() {
+		h.display.CopyCol(fromIndex, toIndex)
+	})
+}
+
+func (h *TinyDisplayHistory) CopyRow(fromIndex, toIndex int) {
+	xSize, _ := h.display.Size()
+	h.overwrite(fmt.Sprintf("copy row y=%d to y=%d", fromIndex, toIndex), h.save(0, toIndex, xSize, 1, false), func() {
+		h.display.CopyRow(fromIndex, toIndex)
+	})
+}
+
+func (h *TinyDisplayHistory) Undo() error {
+	if h.position == 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	h.position--
+	h.steps[h.position].undo()
+	return nil
+}
+
+func (h *TinyDisplayHistory) Redo() error {
+	if h.position == len(h.steps) {
+		return fmt.Errorf("nothing to redo")
+	}
+	h.steps[h.position].apply()
+	h.position++
+	return nil
+}
+
+// Seek undoes or redoes steps until step has been applied last; step 0 is
+// before the first command.
+func (h *TinyDisplayHistory) Seek(step int) error {
+	if step < 0 || step > len(h.steps) {
+		return fmt.Errorf("step `%d` is outside the history of %d steps", step, len(h.steps))
+	}
+	for h.position > step {
+		h.Undo()
+	}
+	for h.position < step {
+		h.Redo()
+	}
+	return nil
+}
+
+// Commands lists the steps, in the syntax TDCommandDispatch reads, whether
+// applied or undone.
+func (h *TinyDisplayHistory) Commands() []string {
+	commands := make([]string, len(h.steps))
+	for j, step := range h.steps {
+		commands[j] = step.command
+	}
+	return commands
+}
+
+// ----------------------------------------
+// reading the letters
+
+const tdGlyphHeight = 6
+const tdCellWidth = 5
+
+// tdFont is the 4x6 font the display writes in, one glyph per letter, each
+// in a 5-column cell. Y is the odd one out, using the whole cell.
+var tdFont = map[rune][]string{
+	'A': {".##.", "#..#", "#..#", "####", "#..#", "#..#"},
+	'B': {"###.", "#..#", "###.", "#..#", "#..#", "###."},
+	'C': {".##.", "#..#", "#...", "#...", "#..#", ".##."},
+	'E': {"####", "#...", "###.", "#...", "#...", "####"},
+	'F': {"####", "#...", "###.", "#...", "#...", "#..."},
+	'G': {".##.", "#..#", "#...", "#.##", "#..#", ".###"},
+	'H': {"#..#", "#..#", "####", "#..#", "#..#", "#..#"},
+	'I': {".###", "..#.", "..#.", "..#.", "..#.", ".###"},
+	'J': {"..##", "...#", "...#", "...#", "#..#", ".##."},
+	'K': {"#..#", "#.#.", "##..", "#.#.", "#.#.", "#..#"},
+	'L': {"#...", "#...", "#...", "#...", "#...", "####"},
+	'O': {".##.", "#..#", "#..#", "#..#", "#..#", ".##."},
+	'P': {"###.", "#..#", "#..#", "###.", "#...", "#..."},
+	'R': {"###.", "#..#", "#..#", "###.", "#.#.", "#..#"},
+	'S': {".###", "#...", "#...", ".##.", "...#", "###."},
+	'U': {"#..#", "#..#", "#..#", "#..#", "#..#", ".##."},
+	'Y': {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#.."},
+	'Z': {"####", "...#", "..#.", ".#..", "#...", "####"},
+	' ': {"....", "....", "....", "....", "....", "...."},
+}
+
+// tdGlyphs looks letters up by their cell, rows padded to the cell width
+// and joined.
+var tdGlyphs = func() map[string]rune {
+	glyphs := make(map[string]rune)
+	for letter, rows := range tdFont {
+		key := ""
+		for _, row := range rows {
+			key += row + strings.Repeat(".", tdCellWidth-len(row))
+		}
+		glyphs[key] = letter
+	}
+	return glyphs
+}()
+
+type UnrecognizedGlyphsError struct {
+	text  string
+	cells []int
+}
+
+func (e UnrecognizedGlyphsError) Error() string {
+	return fmt.Sprintf("display reads `%s`: unrecognized glyphs in cells %v", e.text, e.cells)
+}
+
+// ReadLetters splits the display into cells and looks each one up in the
+// font. Cells it can't read come out as '?', and are listed in an
+// UnrecognizedGlyphsError returned along with the text.
+func (td TinyDisplay) ReadLetters() (string, error) {
+	if td.ySize != tdGlyphHeight {
+		return "", fmt.Errorf("display is `%d` pixels tall, letters are %d", td.ySize, tdGlyphHeight)
+	}
+
+	text := make([]rune, 0, td.xSize/tdCellWidth+1)
+	unrecognized := make([]int, 0)
+	for cell := 0; cell*tdCellWidth < td.xSize; cell++ {
+		key := ""
+		for y := 0; y < td.ySize; y++ {
+			for x := cell * tdCellWidth; x < (cell+1)*tdCellWidth; x++ {
+				if x < td.xSize && td.pixels[y][x] {
+					key += "#"
+				} else {
+					key += "."
+				}
+			}
+		}
+		if letter, ok := tdGlyphs[key]; ok {
+			text = append(text, letter)
+		} else {
+			text = append(text, '?')
+			unrecognized = append(unrecognized, cell)
+		}
+	}
+
+	if len(unrecognized) > 0 {
+		return string(text), UnrecognizedGlyphsError{string(text), unrecognized}
+	}
+	return string(text), nil
+}
+
+// ----------------------------------------
+// images
+
+var tdPalette = color.Palette{
+	color.RGBA{0x0f, 0x0f, 0x23, 0xff}, // off
+	color.RGBA{0xff, 0xff, 0x66, 0xff}, // on
+}
+
+// tdPBMLineWidth is the longest line a plain PBM should have.
+const tdPBMLineWidth = 70
+
+func tdCheckScale(scale int) error {
+	if scale < 1 {
+		return fmt.Errorf("pixel scale `%d` is less than 1", scale)
+	}
+	return nil
+}
+
+// image draws each display pixel as a scale x scale square.
+func (td TinyDisplay) image(scale int) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, td.xSize*scale, td.ySize*scale), tdPalette)
+	for y, row := range td.pixels {
+		for x, pixel := range row {
+			if !pixel {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(x*scale+dx, y*scale+dy, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// WritePBM writes a plain (P1) PBM, where 1 is a lit pixel.
+func (td TinyDisplay) WritePBM(output io.Writer, scale int) error {
+	if err := tdCheckScale(scale); err != nil {
+		return err
+	}
+	img := td.image(scale)
+	bounds := img.Bounds()
+
+	writer := bufio.NewWriter(output)
+	fmt.Fprintf(writer, "P1\n%d %d\n", bounds.Dx(), bounds.Dy())
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			if x > 0 && x%tdPBMLineWidth == 0 {
+				writer.WriteByte('\n')
+			}
+			writer.WriteByte('0' + img.ColorIndexAt(x, y))
+		}
+		writer.WriteByte('\n')
+	}
+	return writer.Flush()
+}
+
+func (td TinyDisplay) WritePNG(output io.Writer, scale int) error {
+	if err := tdCheckScale(scale); err != nil {
+		return err
+	}
+	return png.Encode(output, td.image(scale))
+}
+
+// TinyDisplayRecorder dispatches commands to a display, capturing a frame
+// after each one, starting with the display as it was handed over.
+type TinyDisplayRecorder struct {
+	td     *TinyDisplay
+	scale  int
+	frames []*image.Paletted
+}
+
+func NewTinyDisplayRecorder(td *TinyDisplay, scale int) (*TinyDisplayRecorder, error) {
+	if err := tdCheckScale(scale); err != nil {
+		return nil, err
+	}
+	return &TinyDisplayRecorder{td, scale, []*image.Paletted{td.image(scale)}}, nil
+}
+
+// Dispatch is TDCommandDispatch, plus a frame. Blank lines don't get one.
+func (r *TinyDisplayRecorder) Dispatch(command string) error {
+	if err := TDCommandDispatch(command, r.td); err != nil {
+		return err
+	}
+	if strings.TrimSpace(command) != "" {
+		r.frames = append(r.frames, r.td.image(r.scale))
+	}
+	return nil
+}
+
+// WriteGIF writes the frames as a looping animation, delay hundredths of a
+// second apart, holding the last frame for longer.
+func (r *TinyDisplayRecorder) WriteGIF(output io.Writer, delay int) error {
+	delays := make([]int, len(r.frames))
+	for j := range delays {
+		delays[j] = delay
+	}
+	delays[len(delays)-1] = 50 * delay
+	return gif.EncodeAll(output, &gif.GIF{Image: r.frames, Delay: delays})
+}
+
+var tdImageDir = flag.String("display-images", "", "write the Day 8 display to this directory as PBM, PNG and an animated GIF")
+
+// tdRandomCommand makes a random, valid command for a display of the given
+// size, for checking backends and history against each other.
+func tdRandomCommand(random *rand.Rand, xSize, ySize int) string {
+	x, y := random.Intn(xSize), random.Intn(ySize)
+	by := random.Intn(4*xSize) - 2*xSize
+	switch random.Intn(10) {
+	case 0:
+		return fmt.Sprintf("rect %dx%d", random.Intn(xSize+1), random.Intn(ySize+1))
+	case 1:
+		return fmt.Sprintf("rect %dx%d at %d,%d", random.Intn(xSize-x+1), random.Intn(ySize-y+1), x, y)
+	case 2:
+		return "clear"
+	case 3:
+		return fmt.Sprintf("invert %dx%d at %d,%d", random.Intn(xSize-x+1), random.Intn(ySize-y+1), x, y)
+	case 4:
+		return fmt.Sprintf("rotate row y=%d by %d", y, by)
+	case 5:
+		return fmt.Sprintf("rotate column x=%d by %d", x, by)
+	case 6:
+		return fmt.Sprintf("shift row y=%d by %d", y, by)
+	case 7:
+		return fmt.Sprintf("shift column x=%d by %d", x, by)
+	case 8:
+		return fmt.Sprintf("copy row y=%d to y=%d", y, random.Intn(ySize))
+	default:
+		return fmt.Sprintf("copy column x=%d to x=%d", x, random.Intn(xSize))
+	}
+}
+
 var _ = Describe("Day8", func() {
 	Describe("TinyDisplay", func() {
 		Describe("#String", func() {
@@ -160,6 +996,294 @@ var _ = Describe("Day8", func() {
 				Expect(display.String()).To(Equal("\n#....##\n###....\n.......\n"))
 			})
 		})
+
+		Describe("#RectAt", func() {
+			It("draws a filled rectangle at an offset", func() {
+				display := NewTinyDisplay(7, 3)
+				display.RectAt(5, 1, 2, 1)
+				Expect(display.String()).To(Equal("\n.......\n.....##\n.......\n"))
+			})
+		})
+
+		Describe("#Clear", func() {
+			It("turns every pixel off", func() {
+				display := NewTinyDisplay(7, 3)
+				display.Rect(7, 3)
+				display.Clear()
+				Expect(display.String()).To(Equal("\n.......\n.......\n.......\n"))
+			})
+		})
+
+		Describe("#Invert", func() {
+			It("flips the pixels in a region", func() {
+				display := NewTinyDisplay(7, 3)
+				display.Rect(3, 2)
+				display.Invert(1, 1, 4, 2)
+				Expect(display.String()).To(Equal("\n###....\n#..##..\n.####..\n"))
+			})
+		})
+
+		Describe("negative rotations", func() {
+			It("rotate rows left and columns up", func() {
+				display := NewTinyDisplay(7, 3)
+				display.Rect(3, 2)
+				display.RotateRow(0, -2)
+				display.RotateCol(1, -1)
+				Expect(display.String()).To(Equal("\n##...##\n#.#....\n.......\n"))
+			})
+		})
+
+		Describe("#ShiftRow and #ShiftCol", func() {
+			It("move pixels without wrapping", func() {
+				display := NewTinyDisplay(7, 3)
+				display.Rect(3, 2)
+				display.ShiftRow(0, 5)
+				display.ShiftRow(1, -1)
+				display.ShiftCol(0, 1)
+				Expect(display.String()).To(Equal("\n.....##\n.#.....\n#......\n"))
+			})
+		})
+
+		Describe("#CopyRow and #CopyCol", func() {
+			It("copy one line of pixels over another", func() {
+				display := NewTinyDisplay(7, 3)
+				display.Rect(3, 1)
+				display.CopyRow(0, 2)
+				display.CopyCol(0, 6)
+				Expect(display.String()).To(Equal("\n###...#\n.......\n###...#\n"))
+			})
+		})
+	})
+
+	Describe("BitsetTinyDisplay", func() {
+		It("renders like TinyDisplay after any program", func() {
+			random := rand.New(rand.NewSource(8))
+			for _, size := range [][2]int{{1, 1}, {7, 3}, {50, 6}, {64, 4}, {65, 5}, {130, 7}, {200, 2}} {
+				td, _ := NewTinyDisplayWithBackend("pixels", size[0], size[1])
+				bd, _ := NewTinyDisplayWithBackend("bitset", size[0], size[1])
+				for j := 0; j < 300; j++ {
+					command := tdRandomCommand(random, size[0], size[1])
+					Expect(TDCommandDispatch(command, td)).To(Succeed())
+					Expect(TDCommandDispatch(command, bd)).To(Succeed())
+					Expect(bd.String()).To(Equal(td.String()), command)
+				}
+			}
+		})
+
+		It("rotates very large displays quickly", func() {
+			bd := NewBitsetTinyDisplay(100000, 1000)
+			bd.Rect(3, 2)
+			bd.RectAt(99990, 999, 10, 1)
+			for j := 0; j < 1000; j++ {
+				bd.RotateRow(j%2, 99999)
+				bd.RotateCol(j, 123456789)
+			}
+			Expect(bd.Lit()).To(Equal(16))
+		})
+
+		It("copies out to a TinyDisplay", func() {
+			bd := NewBitsetTinyDisplay(7, 3)
+			bd.Rect(3, 2)
+			bd.RotateRow(0, 5)
+			td := bd.TinyDisplay()
+			Expect(td.String()).To(Equal("\n#....##\n###....\n.......\n"))
+			Expect(td.String()).To(Equal(bd.String()))
+		})
+
+		It("is selected by name", func() {
+			_, err := NewTinyDisplayWithBackend("abacus", 5, 5)
+			Expect(err).To(MatchError("unknown display backend `abacus`"))
+		})
+	})
+
+	Describe("TinyDisplayHistory", func() {
+		It("undoes, redoes and seeks through any program on either backend", func() {
+			random := rand.New(rand.NewSource(50))
+			for _, backend := range []string{"pixels", "bitset"} {
+				for _, size := range [][2]int{{7, 3}, {50, 6}, {70, 4}} {
+					display, _ := NewTinyDisplayWithBackend(backend, size[0], size[1])
+					history := NewTinyDisplayHistory(display)
+					states := []string{display.String()}
+					for j := 0; j < 200; j++ {
+						Expect(TDCommandDispatch(tdRandomCommand(random, size[0], size[1]), history)).To(Succeed())
+						states = append(states, display.String())
+					}
+
+					for j := 0; j < 50; j++ {
+						step := random.Intn(len(states))
+						Expect(history.Seek(step)).To(Succeed())
+						Expect(display.String()).To(Equal(states[step]), fmt.Sprintf("%s %v step %d", backend, size, step))
+					}
+					Expect(history.Seek(0)).To(Succeed())
+					Expect(display.String()).To(Equal(states[0]))
+					Expect(history.Seek(200)).To(Succeed())
+					Expect(display.String()).To(Equal(states[200]))
+				}
+			}
+		})
+
+		It("replays its commands", func() {
+			history := NewTinyDisplayHistory(NewBitsetTinyDisplay(7, 3))
+			program := []string{"rect 3x2", "rotate column x=1 by -1", "shift row y=0 by 2", "invert 2x2 at 5,1", "copy row y=1 to y=2", "clear"}
+			for _, command := range program {
+				Expect(TDCommandDispatch(command, history)).To(Succeed())
+			}
+			Expect(history.Commands()).To(Equal(program))
+		})
+
+		It("steps back and forth one command at a time", func() {
+			td := NewTinyDisplay(7, 3)
+			history := NewTinyDisplayHistory(&td)
+			history.Rect(3, 2)
+			history.RotateRow(0, 5)
+			Expect(td.String()).To(Equal("\n#....##\n###....\n.......\n"))
+
+			Expect(history.Undo()).To(Succeed())
+			Expect(td.String()).To(Equal("\n###....\n###....\n.......\n"))
+			Expect(history.Undo()).To(Succeed())
+			Expect(td.String()).To(Equal("\n.......\n.......\n.......\n"))
+			Expect(history.Undo()).To(MatchError("nothing to undo"))
+
+			Expect(history.Redo()).To(Succeed())
+			Expect(td.String()).To(Equal("\n###....\n###....\n.......\n"))
+		})
+
+		It("forgets undone commands once a new one is applied", func() {
+			td := NewTinyDisplay(7, 3)
+			history := NewTinyDisplayHistory(&td)
+			history.Rect(3, 2)
+			history.RotateRow(0, 5)
+			Expect(history.Undo()).To(Succeed())
+			history.ShiftCol(0, 1)
+			Expect(history.Commands()).To(Equal([]string{"rect 3x2", "shift column x=0 by 1"}))
+			Expect(history.Redo()).To(MatchError("nothing to redo"))
+			Expect(td.String()).To(Equal("\n.##....\n###....\n#......\n"))
+		})
+
+		It("only seeks within the history", func() {
+			history := NewTinyDisplayHistory(NewBitsetTinyDisplay(7, 3))
+			history.Clear()
+			Expect(history.Seek(2)).To(MatchError("step `2` is outside the history of 1 steps"))
+			Expect(history.Seek(-1)).To(MatchError("step `-1` is outside the history of 1 steps"))
+		})
+	})
+
+	Describe("#ReadLetters", func() {
+		var draw = func(td TinyDisplay, x int, rows []string) {
+			for y, row := range rows {
+				for dx, pixel := range row {
+					td.pixels[y][x+dx] = pixel == '#'
+				}
+			}
+		}
+
+		It("reads each 5-column cell as a letter", func() {
+			td := NewTinyDisplay(20, 6)
+			draw(td, 0, tdFont['H'])
+			draw(td, 5, tdFont['I'])
+			draw(td, 15, tdFont['Y'])
+			text, err := td.ReadLetters()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(text).To(Equal("HI Y"))
+		})
+
+		It("pads a short last cell with blank pixels", func() {
+			td := NewTinyDisplay(9, 6)
+			draw(td, 5, tdFont['L'])
+			Expect(td.ReadLetters()).To(Equal(" L"))
+		})
+
+		It("flags the cells it can't read", func() {
+			td := NewTinyDisplay(15, 6)
+			draw(td, 0, tdFont['O'])
+			td.pixels[2][6] = true
+			td.pixels[0][14] = true
+			text, err := td.ReadLetters()
+			Expect(text).To(Equal("O??"))
+			Expect(err).To(MatchError("display reads `O??`: unrecognized glyphs in cells [1 2]"))
+			Expect(err.(UnrecognizedGlyphsError).cells).To(Equal([]int{1, 2}))
+		})
+
+		It("needs a display as tall as the font", func() {
+			_, err := NewTinyDisplay(10, 3).ReadLetters()
+			Expect(err).To(MatchError("display is `3` pixels tall, letters are 6"))
+		})
+	})
+
+	Describe("images", func() {
+		var td TinyDisplay
+		BeforeEach(func() {
+			td = NewTinyDisplay(3, 2)
+			td.Rect(1, 1)
+			td.pixels[1][2] = true
+		})
+
+		Describe("#WritePBM", func() {
+			It("writes a plain PBM at the given scale", func() {
+				output := bytes.Buffer{}
+				Expect(td.WritePBM(&output, 1)).To(Succeed())
+				Expect(output.String()).To(Equal("P1\n3 2\n100\n001\n"))
+
+				output.Reset()
+				Expect(td.WritePBM(&output, 2)).To(Succeed())
+				Expect(output.String()).To(Equal("P1\n6 4\n110000\n110000\n000011\n000011\n"))
+			})
+
+			It("keeps lines to 70 characters", func() {
+				output := bytes.Buffer{}
+				Expect(NewTinyDisplay(50, 1).WritePBM(&output, 2)).To(Succeed())
+				lines := strings.Split(output.String(), "\n")
+				Expect(lines[2]).To(HaveLen(70))
+				Expect(lines[3]).To(HaveLen(30))
+			})
+
+			It("needs a positive scale", func() {
+				Expect(td.WritePBM(&bytes.Buffer{}, 0)).To(MatchError("pixel scale `0` is less than 1"))
+			})
+		})
+
+		Describe("#WritePNG", func() {
+			It("writes a PNG at the given scale", func() {
+				output := bytes.Buffer{}
+				Expect(td.WritePNG(&output, 4)).To(Succeed())
+				img, err := png.Decode(&output)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(img.Bounds()).To(Equal(image.Rect(0, 0, 12, 8)))
+				Expect(color.RGBAModel.Convert(img.At(3, 3))).To(Equal(tdPalette[1]))
+				Expect(color.RGBAModel.Convert(img.At(4, 3))).To(Equal(tdPalette[0]))
+				Expect(color.RGBAModel.Convert(img.At(11, 7))).To(Equal(tdPalette[1]))
+			})
+
+			It("needs a positive scale", func() {
+				Expect(td.WritePNG(&bytes.Buffer{}, -1)).To(MatchError("pixel scale `-1` is less than 1"))
+			})
+		})
+
+		Describe("TinyDisplayRecorder", func() {
+			It("captures a frame per command and animates them", func() {
+				recorder, err := NewTinyDisplayRecorder(&td, 1)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(recorder.Dispatch("rotate row y=0 by 1")).To(Succeed())
+				Expect(recorder.Dispatch("")).To(Succeed())
+				Expect(recorder.Dispatch("clear")).To(Succeed())
+				Expect(recorder.Dispatch("bogus")).To(MatchError("unrecognized command `bogus`"))
+				Expect(recorder.frames).To(HaveLen(3))
+				Expect(recorder.frames[1].ColorIndexAt(1, 0)).To(Equal(uint8(1)))
+				Expect(recorder.frames[2].ColorIndexAt(1, 0)).To(Equal(uint8(0)))
+
+				output := bytes.Buffer{}
+				Expect(recorder.WriteGIF(&output, 5)).To(Succeed())
+				animation, err := gif.DecodeAll(&output)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(animation.Image).To(HaveLen(3))
+				Expect(animation.Delay).To(Equal([]int{5, 5, 250}))
+			})
+
+			It("needs a positive scale", func() {
+				_, err := NewTinyDisplayRecorder(&td, 0)
+				Expect(err).To(MatchError("pixel scale `0` is less than 1"))
+			})
+		})
 	})
 
 	Describe("#TDCommandDispatch", func() {
@@ -207,6 +1331,81 @@ var _ = Describe("Day8", func() {
 				Expect(mtd.arg2).To(Equal(12))
 			})
 		})
+
+		Describe("the extended commands", func() {
+			It("call the matching method with appropriate args on the subject", func() {
+				examples := []struct {
+					command string
+					expect  MockTD
+				}{
+					{"rect 3x2 at 4,1", MockTD{"RectAt", 4, 1, 3, 2}},
+					{"clear", MockTD{"Clear", 0, 0, 0, 0}},
+					{"invert 5x6 at 7,8", MockTD{"Invert", 7, 8, 5, 6}},
+					{"rotate row y=1 by -5", MockTD{"RotateRow", 1, -5, 0, 0}},
+					{"rotate column x=2 by -12", MockTD{"RotateCol", 2, -12, 0, 0}},
+					{"shift row y=3 by -4", MockTD{"ShiftRow", 3, -4, 0, 0}},
+					{"shift column x=4 by 3", MockTD{"ShiftCol", 4, 3, 0, 0}},
+					{"copy row y=0 to y=5", MockTD{"CopyRow", 0, 5, 0, 0}},
+					{"copy column x=6 to x=1", MockTD{"CopyCol", 6, 1, 0, 0}},
+				}
+				for _, example := range examples {
+					mtd := MockTD{}
+					Expect(TDCommandDispatch(example.command, &mtd)).To(Succeed())
+					Expect(mtd).To(Equal(example.expect), example.command)
+				}
+			})
+		})
+
+		Describe("errors", func() {
+			It("ignores blank lines", func() {
+				mtd := MockTD{}
+				Expect(TDCommandDispatch("  ", &mtd)).To(Succeed())
+				Expect(mtd.method).To(Equal(""))
+			})
+
+			It("reports unrecognized commands", func() {
+				mtd := MockTD{}
+				Expect(TDCommandDispatch("rect 3x2 please", &mtd)).To(MatchError("unrecognized command `rect 3x2 please`"))
+				Expect(TDCommandDispatch("rotate row y=-1 by 2", &mtd)).To(MatchError("unrecognized command `rotate row y=-1 by 2`"))
+				Expect(TDCommandDispatch("explode", &mtd)).To(MatchError("unrecognized command `explode`"))
+				Expect(mtd.method).To(Equal(""))
+			})
+
+			It("reports commands that reach off the display", func() {
+				td := NewTinyDisplay(50, 6)
+				for _, command := range []string{
+					"copy row y=9 to y=0", "copy row y=0 to y=6", "rotate row y=6 by 1", "shift row y=6 by 1",
+					"rotate column x=50 by 1", "shift column x=50 by 1", "copy column x=0 to x=50",
+					"rect 51x1", "rect 1x7", "rect 3x2 at 48,0", "invert 1x1 at 0,6",
+					"rect 9223372036854775807x1 at 1,0",
+				} {
+					Expect(TDCommandDispatch(command, &td)).To(MatchError("command `"+command+"` reaches off the 50x6 display"), command)
+				}
+				Expect(td.String()).To(Equal(NewTinyDisplay(50, 6).String()))
+
+				for _, command := range []string{"rect 50x6", "rect 2x2 at 48,4", "rotate row y=5 by 1", "copy column x=49 to x=0"} {
+					Expect(TDCommandDispatch(command, &td)).To(Succeed(), command)
+				}
+				Expect(TDCommandDispatch("rotate row y=6 by 1", NewTinyDisplayHistory(&td))).To(HaveOccurred())
+				Expect(TDCommandDispatch("rotate row y=6 by 1", NewBitsetTinyDisplay(50, 6))).To(HaveOccurred())
+			})
+
+			It("handles displays with no pixels", func() {
+				for _, display := range []TinyDisplayBackend{NewBitsetTinyDisplay(0, 3), NewBitsetTinyDisplay(3, 0)} {
+					td := NewTinyDisplay(display.Size())
+					for _, command := range []string{"rotate row y=0 by 5", "rotate column x=0 by -5", "shift row y=0 by 1", "rect 0x0", "clear"} {
+						TDCommandDispatch(command, display)
+						TDCommandDispatch(command, &td)
+					}
+					Expect(display.String()).To(Equal(td.String()))
+				}
+			})
+
+			It("reports arguments out of range", func() {
+				err := TDCommandDispatch("rect 99999999999999999999x2", &MockTD{})
+				Expect(err).To(MatchError(ContainSubstring("command `rect 99999999999999999999x2`: ")))
+			})
+		})
 	})
 
 	Describe("the puzzle", func() {
@@ -219,7 +1418,7 @@ var _ = Describe("Day8", func() {
 			commands := parseFile("day8.txt")
 			td := NewTinyDisplay(50, 6)
 			for _, command := range commands {
-				TDCommandDispatch(command, &td)
+				Expect(TDCommandDispatch(command, &td)).To(Succeed())
 			}
 
 			litPixels := 0
@@ -232,6 +1431,66 @@ var _ = Describe("Day8", func() {
 			}
 			fmt.Println("star 1: there are", litPixels, "lit pixels")
 			fmt.Println(td)
+
+			text, err := td.ReadLetters()
+			Expect(err).NotTo(HaveOccurred())
+			fmt.Println("star 2: the display reads", text)
+			Expect(text).To(Equal("CFLELOYFCS"))
+		})
+
+		It("gets the same answer from the bitset backend", func() {
+			td := NewTinyDisplay(50, 6)
+			bd := NewBitsetTinyDisplay(50, 6)
+			for _, command := range parseFile("day8.txt") {
+				Expect(TDCommandDispatch(command, &td)).To(Succeed())
+				Expect(TDCommandDispatch(command, bd)).To(Succeed())
+			}
+			Expect(bd.String()).To(Equal(td.String()))
+			Expect(bd.Lit()).To(Equal(106))
+			Expect(bd.TinyDisplay().ReadLetters()).To(Equal("CFLELOYFCS"))
+		})
+
+		It("seeks through the program", func() {
+			td := NewTinyDisplay(50, 6)
+			history := NewTinyDisplayHistory(&td)
+			for _, command := range parseFile("day8.txt") {
+				Expect(TDCommandDispatch(command, history)).To(Succeed())
+			}
+			final := td.String()
+			Expect(history.Seek(len(history.Commands()) / 2)).To(Succeed())
+			Expect(history.Seek(0)).To(Succeed())
+			Expect(td.String()).To(Equal(NewTinyDisplay(50, 6).String()))
+			Expect(history.Seek(len(history.Commands()))).To(Succeed())
+			Expect(td.String()).To(Equal(final))
+		})
+
+		It("records the program as an animation", func() {
+			commands := parseFile("day8.txt")
+			td := NewTinyDisplay(50, 6)
+			recorder, err := NewTinyDisplayRecorder(&td, 8)
+			Expect(err).NotTo(HaveOccurred())
+			frames := 1
+			for _, command := range commands {
+				Expect(recorder.Dispatch(command)).To(Succeed())
+				if command != "" {
+					frames++
+				}
+			}
+			Expect(recorder.frames).To(HaveLen(frames))
+			Expect(recorder.frames[frames-1]).To(Equal(td.image(8)))
+
+			if *tdImageDir != "" {
+				for name, write := range map[string]func(io.Writer) error{
+					"day8.pbm": func(w io.Writer) error { return td.WritePBM(w, 8) },
+					"day8.png": func(w io.Writer) error { return td.WritePNG(w, 8) },
+					"day8.gif": func(w io.Writer) error { return recorder.WriteGIF(w, 4) },
+				} {
+					file, err := os.Create(filepath.Join(*tdImageDir, name))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(write(file)).To(Succeed())
+					Expect(file.Close()).To(Succeed())
+				}
+			}
 		})
 	})
 })